@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// actorKeyPrefix prefixes the keys of the actors' nodes in an ActorRegistry.
+const actorKeyPrefix = "actor."
+
+// ActorInfo describes an actor, for UIs to show who made a change.
+type ActorInfo struct {
+	Name   string `json:"name,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+// ActorRegistry maps client ids to their ActorInfo. It is replicated like
+// any document: it uses its own replica, separate from the replica of the
+// document being edited, where every actor is a node under the root with
+// its info as the value. Each actor only sets its own info, so the last
+// write wins.
+type ActorRegistry struct {
+	r *Replica
+}
+
+// NewActorRegistry returns a registry stored in 'r', which should be a replica
+// used only by the registry, with the same client id as the actor's document
+// replica.
+func NewActorRegistry(r *Replica) *ActorRegistry {
+	return &ActorRegistry{r: r}
+}
+
+// Register sets the info of the registry's own actor, returning the events
+// to send to the other replicas of the registry.
+func (a *ActorRegistry) Register(info ActorInfo) []Event {
+	key := actorKeyPrefix + strconv.Itoa(a.r.ID())
+	value, _ := json.Marshal(info)
+
+	var exists bool
+	a.r.View(func(crdt *CRDT) {
+		n, ok := crdt.nodes[key]
+		exists = ok && n.parent != nil && n.parent.key == rootKey
+	})
+
+	var events []Event
+	if !exists {
+		events = append(events, a.r.Insert(key, rootKey))
+	}
+	return append(events, a.r.Set(key, string(value)))
+}
+
+// Apply applies an event from another replica of the registry.
+func (a *ActorRegistry) Apply(e Event) {
+	a.r.Apply(e)
+}
+
+// Lookup returns the info of the actor, and false if it hasn't registered.
+func (a *ActorRegistry) Lookup(id int) (ActorInfo, bool) {
+	info, exists := a.Actors()[id]
+	return info, exists
+}
+
+// Actors returns the info of every registered actor.
+func (a *ActorRegistry) Actors() map[int]ActorInfo {
+	actors := map[int]ActorInfo{}
+	a.r.View(func(crdt *CRDT) {
+		for n := range crdt.Traverse() {
+			id, err := strconv.Atoi(strings.TrimPrefix(n.key, actorKeyPrefix))
+			if err != nil || !strings.HasPrefix(n.key, actorKeyPrefix) {
+				continue
+			}
+			var info ActorInfo
+			if json.Unmarshal([]byte(n.value), &info) == nil {
+				actors[id] = info
+			}
+		}
+	})
+	return actors
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// Aggregate summarises the descendants of a node.
+type Aggregate struct {
+	// Count is the number of descendants.
+	Count int `json:"count"`
+	// Numbers is the number of descendants whose value is a number, and Sum
+	// the sum of those values.
+	Numbers int     `json:"numbers"`
+	Sum     float64 `json:"sum"`
+	// Depth is how many levels of descendants there are, 0 for a leaf.
+	Depth int `json:"depth"`
+}
+
+// Aggregate returns the Aggregate of the descendants of the node with the
+// key, or of the whole document for the root key, and whether the node is
+// in the document.
+//
+// Aggregates are kept on the nodes once computed. Applying an event only
+// clears those of the item's ancestors, so asking again after an edit
+// recomputes one path of the tree rather than walking all of it.
+func (crdt *CRDT) Aggregate(key string) (Aggregate, bool) {
+	n, exists := crdt.nodes[key]
+	if !exists {
+		return Aggregate{}, false
+	}
+	for p := n; p.key != rootKey; p = p.parent {
+		if p.parent == nil || p.key == ghostKey {
+			// deleted, or waiting for its update.
+			return Aggregate{}, false
+		}
+	}
+	return crdt.aggregate(n), true
+}
+
+// Aggregate returns the Aggregate of the descendants of the node with the
+// key, see CRDT.Aggregate.
+func (r *Replica) Aggregate(key string) (Aggregate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crdt.Aggregate(key)
+}
+
+// aggregate returns the node's Aggregate, computing it and those of its
+// descendants that have been cleared.
+func (crdt *CRDT) aggregate(n *node) Aggregate {
+	if n.aggregate != nil {
+		return *n.aggregate
+	}
+
+	var a Aggregate
+	for _, c := range n.children.slice() {
+		if c.key == ghostKey {
+			continue
+		}
+		ca := crdt.aggregate(c)
+		a.Count += 1 + ca.Count
+		a.Numbers += ca.Numbers
+		a.Sum += ca.Sum
+		if v, err := strconv.ParseFloat(c.value, 64); err == nil && !math.IsInf(v, 0) && !math.IsNaN(v) {
+			a.Numbers++
+			a.Sum += v
+		}
+		if ca.Depth+1 > a.Depth {
+			a.Depth = ca.Depth + 1
+		}
+	}
+	n.aggregate = &a
+	return a
+}
+
+// clearAggregates clears the aggregates of the node's ancestors, which
+// change when the node moves or its value does.
+//
+// A node only has an aggregate when its descendants do, so the first
+// ancestor without one ends the walk.
+func (crdt *CRDT) clearAggregates(key string) {
+	n, exists := crdt.nodes[key]
+	if !exists {
+		return
+	}
+	for p := n.parent; p != nil && p.aggregate != nil; p = p.parent {
+		p.aggregate = nil
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+const (
+	// AnomalyCounter is an event with a counter above ClockGuard.MaxCounter.
+	AnomalyCounter = "counter"
+	// AnomalyJump is an event whose maker's counter is more than
+	// ClockGuard.MaxJump ahead of the last one seen from it.
+	AnomalyJump = "jump"
+	// AnomalyUnseen is an event claiming to know about events of other
+	// actors, or of this replica, that the replica has never seen.
+	AnomalyUnseen = "unseen"
+	// AnomalyBackwards is an event whose maker knows less than it did when
+	// making an earlier event, e.g. because it was restored from a backup
+	// and is reusing counters.
+	AnomalyBackwards = "backwards"
+	// AnomalyRetired is an event made by an actor that has been retired,
+	// see Membership.
+	AnomalyRetired = "retired"
+)
+
+// ClockAnomaly describes a suspicious clock in an event received by a
+// replica.
+type ClockAnomaly struct {
+	Kind   string
+	Actor  int
+	Event  Event
+	Detail string
+}
+
+func (a ClockAnomaly) String() string {
+	return fmt.Sprintf("%s clock from actor %d: %s", a.Kind, a.Actor, a.Detail)
+}
+
+// ClockGuard looks for suspicious clocks in the events a replica receives,
+// see Replica.SetClockGuard. Out of order delivery is also reported as
+// unseen, so whether to reject those events depends on the transport.
+type ClockGuard struct {
+	// MaxCounter is the largest counter accepted, 0 for no limit.
+	MaxCounter int
+	// MaxJump is how far ahead of the last seen counter of an actor its
+	// next event can be, 0 for no limit.
+	MaxJump int
+	// Retired, if set, reports whether an actor has been retired, so its
+	// new events are suspicious.
+	Retired func(actor int) bool
+	// Policy is called with every anomaly found, and rejects the event by
+	// returning an error. If nil, anomalies are logged as warnings and the
+	// events are applied.
+	Policy func(ClockAnomaly) error
+
+	// latest is the clock of the latest event made by each actor.
+	latest map[int]VectorClock
+}
+
+// check looks for anomalies in the event, received by replica 'self' having
+// seen 'seen', and returns the error of the first one the policy rejects.
+func (g *ClockGuard) check(self int, seen VectorClock, e Event, logger *slog.Logger) error {
+	actor := eventActor(seen, e.VectorClock)
+
+	var anomalies []ClockAnomaly
+	report := func(kind, format string, args ...interface{}) {
+		anomalies = append(anomalies, ClockAnomaly{Kind: kind, Actor: actor, Event: e, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	ids := make([]int, 0, len(e.VectorClock))
+	for id := range e.VectorClock {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		t := e.VectorClock[id]
+		if g.MaxCounter > 0 && t > g.MaxCounter {
+			report(AnomalyCounter, "counter %d of actor %d is above %d", t, id, g.MaxCounter)
+		}
+		switch {
+		case t <= seen[id]:
+		case id == self || id != actor:
+			report(AnomalyUnseen, "counter %d of actor %d is ahead of %d", t, id, seen[id])
+		case g.MaxJump > 0 && t-seen[id] > g.MaxJump:
+			report(AnomalyJump, "counter jumped from %d to %d", seen[id], t)
+		}
+	}
+
+	if g.Retired != nil && actor != -1 && g.Retired(actor) {
+		report(AnomalyRetired, "actor %d has been retired", actor)
+	}
+
+	if latest, exists := g.latest[actor]; exists && actor != -1 {
+		for _, id := range ids {
+			if t := e.VectorClock[id]; t < latest[id] {
+				report(AnomalyBackwards, "counter of actor %d went back from %d to %d", id, latest[id], t)
+			}
+		}
+		for id, t := range latest {
+			if _, exists := e.VectorClock[id]; !exists {
+				report(AnomalyBackwards, "counter of actor %d went back from %d to 0", id, t)
+			}
+		}
+	}
+
+	for _, a := range anomalies {
+		if g.Policy == nil {
+			logger.Warn("clock anomaly", "kind", a.Kind, "actor", a.Actor, "clock", e.VectorClock, "detail", a.Detail)
+		} else if err := g.Policy(a); err != nil {
+			return err
+		}
+	}
+
+	if actor != -1 {
+		if g.latest == nil {
+			g.latest = map[int]VectorClock{}
+		}
+		g.latest[actor] = e.VectorClock.Copy()
+	}
+	return nil
+}
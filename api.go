@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// apiNode is the resource representation of a node.
+type apiNode struct {
+	Key      string      `json:"key"`
+	Value    string      `json:"value"`
+	Parent   string      `json:"parent,omitempty"`
+	Children []string    `json:"children"`
+	Clock    VectorClock `json:"clock"`
+}
+
+// apiNewChild is the request body for adding a child to a node.
+type apiNewChild struct {
+	// Key of the child, a new key is generated if it is empty.
+	Key string `json:"key"`
+}
+
+// NewAPI returns an http.Handler exposing the replica's document as
+// resources, so that clients can edit it without implementing the CRDT:
+//
+//	GET    /nodes                 the nodes in order, a page at a time with ?limit=
+//	GET    /nodes/{key}           a single node, use _root for the root
+//	PUT    /nodes/{key}           set the value of the node to the request body
+//	POST   /nodes/{key}/children  add a child, moving it if it already exists
+//	DELETE /nodes/{key}           delete the node
+//
+// Every change is turned into an event generated by the replica. Nodes are
+// served with an ETag of their version, and PUT and DELETE with If-Match
+// fail with 412 Precondition Failed if the node changed since. Changes the
+// replica would reject, see Replica.Check, fail with 422 Unprocessable
+// Entity, see errorStatus.
+//
+// Pages of the list come from a snapshot of the document taken for the
+// first page, so edits made while paging don't shift nodes between pages.
+// The token of the next page is returned in the CRDT-Next-Page header, to
+// be passed as ?page= along with the limit. Only the most recent snapshots
+// are kept, and pages of older ones fail with 410 Gone.
+func NewAPI(r *Replica) http.Handler {
+	pages := &apiPages{}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(req.URL.Path, "/")
+		parts := strings.Split(path, "/")
+		if parts[0] != "nodes" || len(parts) > 3 || (len(parts) == 3 && parts[2] != "children") {
+			http.NotFound(w, req)
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && req.Method == http.MethodGet && req.URL.Query().Has("limit"):
+			pages.list(w, r, req.URL.Query().Get("page"), req.URL.Query().Get("limit"))
+		case len(parts) == 1 && req.Method == http.MethodGet:
+			apiList(w, r)
+		case len(parts) == 2 && req.Method == http.MethodGet:
+			apiGet(w, r, parts[1])
+		case len(parts) == 2 && req.Method == http.MethodPut:
+			apiSet(w, req, r, parts[1])
+		case len(parts) == 2 && req.Method == http.MethodDelete:
+			apiDelete(w, req, r, parts[1])
+		case len(parts) == 3 && req.Method == http.MethodPost:
+			apiAddChild(w, req, r, parts[1])
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func apiList(w http.ResponseWriter, r *Replica) {
+	nodes := []apiNode{}
+	r.View(func(crdt *CRDT) {
+		for n := range crdt.Traverse() {
+			nodes = append(nodes, newAPINode(n))
+		}
+	})
+	apiWrite(w, http.StatusOK, nodes)
+}
+
+func apiGet(w http.ResponseWriter, r *Replica, key string) {
+	var an apiNode
+	found := false
+	r.View(func(crdt *CRDT) {
+		if n, ok := apiLookup(crdt, key); ok {
+			an, found = newAPINode(n), true
+		}
+	})
+	if !found {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", apiETag(r.Version(key)))
+	apiWrite(w, http.StatusOK, an)
+}
+
+func apiSet(w http.ResponseWriter, req *http.Request, r *Replica, key string) {
+	value, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !apiExists(r, key) || key == rootKey {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	e := Event{Type: "set", ItemKey: key, Value: string(value)}
+	if err := r.Check(e); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if !apiApply(w, req, r, e) {
+		return
+	}
+	apiGet(w, r, key)
+}
+
+func apiDelete(w http.ResponseWriter, req *http.Request, r *Replica, key string) {
+	if !apiExists(r, key) || key == rootKey {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	if !apiApply(w, req, r, Event{Type: "delete", ItemKey: key}) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiApply generates the event, only if the node hasn't changed when the
+// request has an If-Match header. It returns false if it wrote an error.
+func apiApply(w http.ResponseWriter, req *http.Request, r *Replica, e Event) bool {
+	match := req.Header.Get("If-Match")
+	if match == "" {
+		switch e.Type {
+		case "set":
+			r.Set(e.ItemKey, e.Value)
+		case "delete":
+			r.Delete(e.ItemKey)
+		}
+		return true
+	}
+
+	expected, err := DecodeSessionToken(strings.Trim(match, `"`))
+	if err != nil {
+		http.Error(w, "invalid If-Match", http.StatusBadRequest)
+		return false
+	}
+	if _, err := r.ApplyIfUnchanged(e, expected); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return false
+	}
+	return true
+}
+
+// errorStatus returns the status of the response to a request that failed
+// with the error, telling clients why by the errors it wraps.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrChanged):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNoView):
+		return http.StatusNotFound
+	case errors.Is(err, ErrClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrUnknownEventType), errors.Is(err, ErrCycleRejected), errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrInvalidValue):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// apiETag returns the ETag of a node version.
+func apiETag(version VectorClock) string {
+	return `"` + EncodeSessionToken(version) + `"`
+}
+
+func apiAddChild(w http.ResponseWriter, req *http.Request, r *Replica, key string) {
+	var body apiNewChild
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !apiExists(r, key) {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	if body.Key == rootKey || body.Key == ghostKey || strings.Contains(body.Key, "/") {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	if body.Key == "" {
+		body.Key = r.NewKey()
+		status = http.StatusCreated
+	} else if !apiExists(r, body.Key) {
+		status = http.StatusCreated
+	}
+	if err := r.Check(Event{Type: "update", ItemKey: body.Key, TargetItemKey: key}); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// the target can have been moved under the item since the check.
+	if _, err := r.TryInsert(body.Key, key); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var an apiNode
+	r.View(func(crdt *CRDT) {
+		an = newAPINode(crdt.nodes[body.Key])
+	})
+	apiWrite(w, status, an)
+}
+
+// apiLookup returns the node for 'key' if it is part of the document, i.e.
+// it is the root or not a ghost node.
+func apiLookup(crdt *CRDT, key string) (*node, bool) {
+	n, exists := crdt.nodes[key]
+	if !exists || key == ghostKey || (key != rootKey && n.parent.key == ghostKey) {
+		return nil, false
+	}
+	return n, true
+}
+
+func apiExists(r *Replica, key string) bool {
+	var exists bool
+	r.View(func(crdt *CRDT) {
+		_, exists = apiLookup(crdt, key)
+	})
+	return exists
+}
+
+func newAPINode(n *node) apiNode {
+	an := apiNode{
+		Key:      n.key,
+		Value:    n.value,
+		Children: []string{},
+		Clock:    n.latestVectorClock,
+	}
+	if n.parent != nil {
+		an.Parent = n.parent.key
+	}
+	for _, c := range n.children.slice() {
+		if c.key != ghostKey {
+			an.Children = append(an.Children, c.key)
+		}
+	}
+	return an
+}
+
+func apiWrite(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
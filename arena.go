@@ -0,0 +1,30 @@
+package main
+
+// nodeArena allocates nodes in blocks, so that a document with many nodes
+// is made of a few large allocations instead of one per node. Nodes are
+// never freed individually, so a block lives as long as the CRDT does.
+type nodeArena struct {
+	block []node
+	size  int
+}
+
+func (a *nodeArena) alloc() *node {
+	if len(a.block) == 0 {
+		a.block = make([]node, a.size)
+	}
+	n := &a.block[0]
+	a.block = a.block[1:]
+	return n
+}
+
+// SetArena makes the CRDT allocate new nodes in blocks of 'size' nodes,
+// which reduces the number of allocations and the work the garbage
+// collector has to do for documents that create many nodes, at the cost of
+// up to a block of unused memory. A size less than 2 turns it off.
+func (crdt *CRDT) SetArena(size int) {
+	if size < 2 {
+		crdt.arena = nil
+		return
+	}
+	crdt.arena = &nodeArena{size: size}
+}
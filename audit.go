@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AuditEntry records an event applied to a replica.
+type AuditEntry struct {
+	// Actor is the client id that made the event.
+	Actor int
+	// Received is when the replica applied the event.
+	Received time.Time
+	// Node is the key of the node the event changed.
+	Node  string
+	Event Event
+}
+
+// AuditLog records every event applied to a replica, see Replica.SetAudit,
+// and answers who changed what and when. Entries are kept in memory, and
+// written to the log's writer as JSON lines so they can be loaded again.
+// It is safe for concurrent use.
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	enc     *json.Encoder
+	err     error
+}
+
+// NewAuditLog returns an empty AuditLog writing its entries to 'w', or only
+// keeping them in memory if 'w' is nil.
+func NewAuditLog(w io.Writer) *AuditLog {
+	a := &AuditLog{}
+	if w != nil {
+		a.enc = json.NewEncoder(w)
+	}
+	return a
+}
+
+// Load reads entries written by an AuditLog, e.g. before a restart, adding
+// them to the log without writing them again.
+func (a *AuditLog) Load(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var entry AuditEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading audit log: %w", err)
+		}
+		a.mu.Lock()
+		a.entries = append(a.entries, entry)
+		a.mu.Unlock()
+	}
+}
+
+// Record adds an entry for the event.
+func (a *AuditLog) Record(e Event, actor int, received time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry := AuditEntry{Actor: actor, Received: received, Node: e.ItemKey, Event: e}
+	a.entries = append(a.entries, entry)
+	if a.enc != nil && a.err == nil {
+		a.err = a.enc.Encode(entry)
+	}
+}
+
+// Err returns the first error writing the entries, if there was one. Entries
+// recorded after it are only kept in memory.
+func (a *AuditLog) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// ByNode returns the entries of events that changed the node, oldest first.
+func (a *AuditLog) ByNode(key string) []AuditEntry {
+	return a.filter(func(entry AuditEntry) bool { return entry.Node == key })
+}
+
+// ByActor returns the entries of events made by the actor, oldest first.
+func (a *AuditLog) ByActor(id int) []AuditEntry {
+	return a.filter(func(entry AuditEntry) bool { return entry.Actor == id })
+}
+
+// Between returns the entries of events received from 'from', up to but not
+// including 'to', oldest first.
+func (a *AuditLog) Between(from, to time.Time) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// entries are recorded as they are received, so are in time order.
+	start := sort.Search(len(a.entries), func(i int) bool { return !a.entries[i].Received.Before(from) })
+	end := sort.Search(len(a.entries), func(i int) bool { return !a.entries[i].Received.Before(to) })
+	if end < start {
+		end = start
+	}
+	return append([]AuditEntry(nil), a.entries[start:end]...)
+}
+
+func (a *AuditLog) filter(match func(AuditEntry) bool) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var entries []AuditEntry
+	for _, entry := range a.entries {
+		if match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// eventActor guesses which client made an event with clock 'clock', given
+// the clock 'seen' of everything applied before it. Delivered in causal
+// order, only the maker's entry is ahead of 'seen'. If there are more, the
+// lowest id is picked, and if none are ahead, e.g. for a duplicate, it
+// returns -1.
+func eventActor(seen, clock VectorClock) int {
+	actor := -1
+	for id, t := range clock {
+		if t > seen[id] && (actor == -1 || id < actor) {
+			actor = id
+		}
+	}
+	return actor
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// batcher collects the local events of a replica over a window, so that a
+// burst of them, e.g. from typing, is broadcast at once.
+type batcher struct {
+	window time.Duration
+	send   func(batch []byte)
+
+	// sending is held from taking a batch until it is sent, so batches are
+	// sent in order.
+	sending sync.Mutex
+	events  []Event
+	timer   *time.Timer
+}
+
+// SetBatching makes the replica broadcast its local events by calling
+// 'send' with batches of them, encoded as newline delimited JSON like the
+// body of a POST to /events. A batch is sent 'window' after the first
+// event in it, and holds every local event made in between. Batches are
+// sent one at a time and in order, by a timer goroutine or by FlushBatch.
+// A nil 'send' turns batching off, dropping the events not sent yet.
+func (r *Replica) SetBatching(window time.Duration, send func(batch []byte)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.batch != nil && r.batch.timer != nil {
+		r.batch.timer.Stop()
+	}
+	r.batch = nil
+	if send != nil {
+		r.batch = &batcher{window: window, send: send}
+	}
+}
+
+// FlushBatch sends the local events waiting for the batching window to
+// end straight away, see SetBatching.
+func (r *Replica) FlushBatch() {
+	r.mu.Lock()
+	b := r.batch
+	r.mu.Unlock()
+	if b != nil {
+		r.flushBatch(b)
+	}
+}
+
+// batchEvent adds a local event to the batch, starting the window if it's
+// the first. r.mu must be held.
+func (r *Replica) batchEvent(e Event) {
+	b := r.batch
+	b.events = append(b.events, e)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, func() { r.flushBatch(b) })
+	}
+}
+
+func (r *Replica) flushBatch(b *batcher) {
+	b.sending.Lock()
+	defer b.sending.Unlock()
+
+	r.mu.Lock()
+	events := b.events
+	b.events = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	r.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		enc.Encode(e)
+	}
+	b.send(buf.Bytes())
+}
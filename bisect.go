@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Bisection is the result of Bisect.
+type Bisection struct {
+	// Index is the position of Event in the first log.
+	Index int
+	// Event is the earliest event of the first log that changes the
+	// outcome when it is moved from where the second log applied it to
+	// where the first log did.
+	Event Event
+	// Divergence is the orders with the event either side of the move,
+	// shrunk to a minimal reproducer.
+	Divergence *Divergence
+}
+
+func (b *Bisection) String() string {
+	e := b.Event
+	return fmt.Sprintf("event %d of the first log, %s %s -> %s %q %s, changes the outcome depending on when it is applied\nminimal reproducer:\n%s",
+		b.Index, e.Type, e.ItemKey, e.TargetItemKey, e.Value, canonicalClock(e.VectorClock), b.Divergence)
+}
+
+// Bisect finds why two replicas that applied the same events, in the orders
+// of the logs 'a' and 'b', ended up with different documents. It moves the
+// events of 'b' into the order of 'a', a prefix of 'a' at a time, bisecting
+// for the first event whose move changes the document. Events applied more
+// than once by a replica only count the first time.
+func Bisect(a, b []Event) (*Bisection, error) {
+	a, b = uniqueEvents(a), uniqueEvents(b)
+	index := map[string]int{}
+	for i, e := range a {
+		index[eventID(e)] = i
+	}
+	second := make([]int, 0, len(b))
+	for _, e := range b {
+		i, exists := index[eventID(e)]
+		if !exists {
+			return nil, fmt.Errorf("only the second log has %s %s %s, the replicas haven't applied the same events", e.Type, e.ItemKey, canonicalClock(e.VectorClock))
+		}
+		second = append(second, i)
+	}
+	if len(second) != len(a) {
+		return nil, errors.New("the first log has events the second doesn't, the replicas haven't applied the same events")
+	}
+
+	// order returns the events of 'a' in the order of 'b', except the first
+	// k events of 'a' which are moved to the front, in the order of 'a'.
+	order := func(k int) []int {
+		o := make([]int, 0, len(a))
+		for i := 0; i < k; i++ {
+			o = append(o, i)
+		}
+		for _, i := range second {
+			if i >= k {
+				o = append(o, i)
+			}
+		}
+		return o
+	}
+
+	want := applyInOrder(a, order(len(a)))
+	if applyInOrder(a, order(0)) == want {
+		return nil, errors.New("the logs result in the same document")
+	}
+
+	// order(lo) results in a different document from 'a' and order(hi) in
+	// the same one.
+	lo, hi := 0, len(a)
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if applyInOrder(a, order(mid)) == want {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	return &Bisection{
+		Index:      hi - 1,
+		Event:      a[hi-1],
+		Divergence: minimizeDivergence(diverges(a, order(hi), order(lo))),
+	}, nil
+}
+
+// eventID identifies an event amongst the events of a document.
+func eventID(e Event) string {
+	return fmt.Sprintf("%s %q %q %q %s", e.Type, e.ItemKey, e.TargetItemKey, e.Value, canonicalClock(e.VectorClock))
+}
+
+// uniqueEvents returns the events without repeats, keeping the first.
+func uniqueEvents(events []Event) []Event {
+	seen := map[string]bool{}
+	unique := make([]Event, 0, len(events))
+	for _, e := range events {
+		if id := eventID(e); !seen[id] {
+			seen[id] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// runBisect reads the logs of two replicas that diverged, as newline
+// delimited JSON like check, and prints the event whose order made them
+// diverge.
+func runBisect(args []string) error {
+	fs := flag.NewFlagSet("bisect", flag.ContinueOnError)
+	goCode := fs.Bool("go", false, "print the reproducer as Go code")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: bisect [-go] <first log> <second log>")
+	}
+
+	var logs [2][]Event
+	for i, file := range fs.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(f)
+		for {
+			var e Event
+			if err = dec.Decode(&e); err != nil {
+				break
+			}
+			logs[i] = append(logs[i], e)
+		}
+		f.Close()
+		if err != io.EOF {
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+	}
+
+	b, err := Bisect(logs[0], logs[1])
+	if err != nil {
+		return err
+	}
+	if *goCode {
+		fmt.Print(b.Divergence.GoString())
+		return nil
+	}
+	fmt.Print(b)
+	return nil
+}
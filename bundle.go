@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// bundleVersion is the version of the bundle format written by
+// ExportBundle.
+const bundleVersion = 1
+
+// Bundle is everything needed to carry a document to another device, or
+// attach it to a bug report, in a single JSON file.
+type Bundle struct {
+	Version int `json:"version"`
+	// ID is the client id of the replica the bundle was exported from.
+	ID       int       `json:"id"`
+	Exported time.Time `json:"exported"`
+	// Snapshot is the clock and document when the bundle was exported, to
+	// read the document without restoring it.
+	Snapshot json.RawMessage `json:"snapshot"`
+	// Log is every event the replica had applied, in the order it applied
+	// them, which is what the replica is restored from.
+	Log []Event `json:"log"`
+	// Actors is the log of the replica's ActorRegistry, if it has one.
+	Actors   []Event           `json:"actors,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ExportBundle writes a Bundle of the replica to 'w'. The events of the
+// replica's ActorRegistry are included if 'actors' isn't nil.
+func (r *Replica) ExportBundle(w io.Writer, actors *ActorRegistry) error {
+	b := Bundle{Version: bundleVersion, ID: r.id, Exported: time.Now().UTC()}
+
+	// the snapshot and log are taken together, so they agree.
+	r.mu.Lock()
+	b.Snapshot = r.snapshot()
+	b.Log = feedEvents(r.feed)
+	if len(r.metadata) > 0 {
+		b.Metadata = make(map[string]string, len(r.metadata))
+		for k, v := range r.metadata {
+			b.Metadata[k] = v
+		}
+	}
+	r.mu.Unlock()
+
+	if actors != nil {
+		b.Actors = feedEvents(actors.r.feed)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// ImportBundle reads a Bundle written by ExportBundle.
+func ImportBundle(in io.Reader) (*Bundle, error) {
+	var b Bundle
+	if err := json.NewDecoder(in).Decode(&b); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	return &b, nil
+}
+
+// Replica restores the replica the bundle was exported from, with its
+// client id and metadata, configured by the options. Passing WithID
+// restores it under another client id, e.g. on a device that already uses
+// the bundle's.
+func (b *Bundle) Replica(opts ...Option) *Replica {
+	return New(append([]Option{WithID(b.ID), WithMetadata(b.Metadata), WithEvents(b.Log)}, opts...)...)
+}
+
+// ActorRegistry restores the bundle's ActorRegistry, or returns nil if it
+// doesn't have one.
+func (b *Bundle) ActorRegistry() *ActorRegistry {
+	if b.Actors == nil {
+		return nil
+	}
+	return NewActorRegistry(RestoreReplica(b.ID, b.Actors))
+}
+
+// feedEvents returns every event in the feed, oldest first.
+func feedEvents(f *Feed) []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event{}, f.events...)
+}
@@ -0,0 +1,119 @@
+//go:build capi
+
+package main
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+
+// crdt_visit_fn is called by crdt_traverse for every node of the document,
+// in order. 'value' is empty for nodes without one, and the children of the
+// root are at depth 1. The strings are only valid during the call.
+typedef void (*crdt_visit_fn)(const char *key, const char *value, int depth, void *ctx);
+
+static inline void crdt_call_visit(crdt_visit_fn fn, const char *key, const char *value, int depth, void *ctx) {
+	fn(key, value, depth, ctx);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// The functions below make up a C API for embedding replicas, built with:
+//
+//	go build -tags capi -buildmode=c-shared -o libcrdt.so
+//
+// which also writes libcrdt.h. Replicas are referred to by opaque handles.
+// Events and snapshots are JSON strings, in the same encoding the server
+// uses, and every string returned must be freed with crdt_free_string.
+
+//export crdt_new_replica
+func crdt_new_replica(id C.int) C.uintptr_t {
+	return C.uintptr_t(cgo.NewHandle(NewReplica(int(id))))
+}
+
+//export crdt_free_replica
+func crdt_free_replica(h C.uintptr_t) {
+	cgo.Handle(h).Delete()
+}
+
+//export crdt_free_string
+func crdt_free_string(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+//export crdt_new_key
+func crdt_new_key(h C.uintptr_t) *C.char {
+	return C.CString(capiReplica(h).NewKey())
+}
+
+// crdt_insert moves 'item' under 'target', returning the event, or NULL if
+// 'target' is 'item' or one of its descendants.
+//
+//export crdt_insert
+func crdt_insert(h C.uintptr_t, item, target *C.char) *C.char {
+	e, err := capiReplica(h).TryInsert(C.GoString(item), C.GoString(target))
+	if err != nil {
+		return nil
+	}
+	return capiEvent(e)
+}
+
+//export crdt_delete
+func crdt_delete(h C.uintptr_t, item *C.char) *C.char {
+	return capiEvent(capiReplica(h).Delete(C.GoString(item)))
+}
+
+//export crdt_set
+func crdt_set(h C.uintptr_t, item, value *C.char) *C.char {
+	return capiEvent(capiReplica(h).Set(C.GoString(item), C.GoString(value)))
+}
+
+// crdt_apply applies an event from another replica, returning 0, or -1 if
+// the event isn't valid JSON.
+//
+//export crdt_apply
+func crdt_apply(h C.uintptr_t, event *C.char) C.int {
+	var e Event
+	if err := json.Unmarshal([]byte(C.GoString(event)), &e); err != nil {
+		return -1
+	}
+	capiReplica(h).Apply(e)
+	return 0
+}
+
+// crdt_snapshot returns the clock and document of the replica as JSON.
+//
+//export crdt_snapshot
+func crdt_snapshot(h C.uintptr_t) *C.char {
+	return C.CString(string(replicaSnapshot(capiReplica(h))))
+}
+
+//export crdt_traverse
+func crdt_traverse(h C.uintptr_t, fn C.crdt_visit_fn, ctx unsafe.Pointer) {
+	capiReplica(h).View(func(crdt *CRDT) {
+		for n := range crdt.Traverse() {
+			depth := 0
+			for p := n.parent; p != nil; p = p.parent {
+				depth++
+			}
+			key, value := C.CString(n.key), C.CString(n.value)
+			C.crdt_call_visit(fn, key, value, C.int(depth), ctx)
+			C.free(unsafe.Pointer(key))
+			C.free(unsafe.Pointer(value))
+		}
+	})
+}
+
+func capiReplica(h C.uintptr_t) *Replica {
+	return cgo.Handle(h).Value().(*Replica)
+}
+
+func capiEvent(e Event) *C.char {
+	b, _ := json.Marshal(e)
+	return C.CString(string(b))
+}
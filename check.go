@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// runCheck reads events as newline delimited JSON, like watch, and checks
+// that they converge whatever order they are applied in.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	file := fs.String("f", "-", "file to read events from, - for stdin")
+	samples := fs.Int("samples", 100000, "maximum number of orders to try")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "seed used to pick orders when there are too many to try them all")
+	goCode := fs.Bool("go", false, "print a divergence as Go code reproducing it")
+	reference := fs.Bool("reference", false, "also compare the events, in order, with a reference implementation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	events := []Event{}
+	dec := json.NewDecoder(in)
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		events = append(events, e)
+	}
+
+	if *reference {
+		if err := CheckReference(events); err != nil {
+			return err
+		}
+	}
+
+	if d := CheckConvergence(events, *samples, *seed); d != nil {
+		if *goCode {
+			fmt.Print(d.GoString())
+		} else {
+			fmt.Print(d)
+		}
+		return fmt.Errorf("events diverge (seed %d)", *seed)
+	}
+	fmt.Printf("%d events converge (seed %d)\n", len(events), *seed)
+	return nil
+}
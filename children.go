@@ -0,0 +1,178 @@
+package main
+
+import "math/rand"
+
+// children is the ordered list of children of a node. It is stored as a
+// treap (a randomly balanced binary tree) ordered by position, whose links
+// live in the child nodes themselves. This makes finding the child at an
+// index, inserting at an index and removing a child O(log n), which matters
+// for nodes with tens of thousands of children.
+type children struct {
+	root *node
+}
+
+// treapLinks are the fields of a node that place it in its parent's children.
+type treapLinks struct {
+	left, right, up *node
+	// size is the number of nodes in the subtree rooted at this node.
+	size int
+	// priority is random, and parents always have a higher priority than
+	// their children, which keeps the tree balanced.
+	priority uint32
+}
+
+// len returns the number of children.
+func (c *children) len() int {
+	return treapSize(c.root)
+}
+
+// at returns the child at index 'i', which must be in range.
+func (c *children) at(i int) *node {
+	t := c.root
+	for {
+		left := treapSize(t.left)
+		switch {
+		case i < left:
+			t = t.left
+		case i == left:
+			return t
+		default:
+			i -= left + 1
+			t = t.right
+		}
+	}
+}
+
+// first returns the first child, or nil if there are none.
+func (c *children) first() *node {
+	if c.root == nil {
+		return nil
+	}
+	return treapFirst(c.root)
+}
+
+// slice returns the children in order. It is a copy, so the children can be
+// changed while it is iterated over.
+func (c *children) slice() []*node {
+	s := make([]*node, 0, c.len())
+	for n := c.first(); n != nil; n = n.nextSibling() {
+		s = append(s, n)
+	}
+	return s
+}
+
+// insertAt inserts 'n' so that it becomes the child at index 'i'.
+func (c *children) insertAt(i int, n *node) {
+	n.left, n.right, n.up = nil, nil, nil
+	n.size = 1
+	n.priority = rand.Uint32()
+
+	l, r := treapSplit(c.root, i)
+	c.root = treapMerge(treapMerge(l, n), r)
+	c.root.up = nil
+}
+
+// remove removes 'n', which must be one of the children.
+func (c *children) remove(n *node) {
+	sub := treapMerge(n.left, n.right)
+	up := n.up
+	if sub != nil {
+		sub.up = up
+	}
+
+	switch {
+	case up == nil:
+		c.root = sub
+	case up.left == n:
+		up.left = sub
+	default:
+		up.right = sub
+	}
+	for t := up; t != nil; t = t.up {
+		t.size = 1 + treapSize(t.left) + treapSize(t.right)
+	}
+
+	n.left, n.right, n.up = nil, nil, nil
+}
+
+// nextSibling returns the child after 'n' in its parent's children, or nil
+// if it is the last one.
+func (n *node) nextSibling() *node {
+	if n.right != nil {
+		return treapFirst(n.right)
+	}
+	for t := n; t.up != nil; t = t.up {
+		if t.up.left == t {
+			return t.up
+		}
+	}
+	return nil
+}
+
+func treapSize(t *node) int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
+
+func treapFirst(t *node) *node {
+	for t.left != nil {
+		t = t.left
+	}
+	return t
+}
+
+// treapUpdate recomputes the size of 't' and points its children back at it.
+func treapUpdate(t *node) {
+	t.size = 1 + treapSize(t.left) + treapSize(t.right)
+	if t.left != nil {
+		t.left.up = t
+	}
+	if t.right != nil {
+		t.right.up = t
+	}
+}
+
+// treapSplit splits 't' into a treap of its first 'k' nodes and a treap of
+// the rest.
+func treapSplit(t *node, k int) (*node, *node) {
+	if t == nil {
+		return nil, nil
+	}
+	if treapSize(t.left) >= k {
+		l, r := treapSplit(t.left, k)
+		t.left = r
+		treapUpdate(t)
+		if l != nil {
+			l.up = nil
+		}
+		return l, t
+	}
+	l, r := treapSplit(t.right, k-treapSize(t.left)-1)
+	t.right = l
+	treapUpdate(t)
+	if r != nil {
+		r.up = nil
+	}
+	return t, r
+}
+
+// treapMerge joins two treaps, with all of the nodes in 'l' coming before
+// all of the nodes in 'r'.
+func treapMerge(l, r *node) *node {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.priority > r.priority {
+		l.right = treapMerge(l.right, r)
+		treapUpdate(l)
+		return l
+	}
+	r.left = treapMerge(l, r.left)
+	treapUpdate(r)
+	return r
+}
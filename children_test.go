@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkAttachChild(b *testing.B) {
+	for _, size := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprintf("children=%d", size), func(b *testing.B) {
+			parent := &node{key: "parent"}
+			kids := make([]*node, size)
+			for i := range kids {
+				kids[i] = &node{key: fmt.Sprint(i), latestVectorClock: VectorClock{1: i + 1}}
+				parent.AttachChild(kids[i])
+			}
+			b.ResetTimer()
+
+			// moving a child to the front detaches it from its place
+			// and inserts it again.
+			for i := 0; i < b.N; i++ {
+				c := kids[i%size]
+				c.latestVectorClock = VectorClock{1: size + i + 1}
+				parent.AttachChild(c)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package clock_test
+
+import (
+	"testing"
+
+	"github.com/dlmiddlecote/crdt/clock"
+)
+
+// The API integrators rely on. Changing any of it stops this file from
+// compiling, so that breaking changes are made on purpose.
+var (
+	_ clock.VectorClock                               = map[int]int{}
+	_ func(clock.VectorClock, clock.VectorClock) bool = clock.VectorClock.Before
+	_ func(clock.VectorClock) clock.VectorClock       = clock.VectorClock.Copy
+	_ func(clock.VectorClock, clock.VectorClock)      = clock.VectorClock.Merge
+	_ func(clock.VectorClock, clock.VectorClock) bool = clock.VectorClock.Covers
+)
+
+// TestBefore pins down the order of clocks, which every replica has to
+// agree on, including the tie-break on the number of entries.
+func TestBefore(t *testing.T) {
+	for _, tt := range []struct {
+		v, other clock.VectorClock
+		want     bool
+	}{
+		{clock.VectorClock{1: 1}, clock.VectorClock{1: 2}, true},
+		{clock.VectorClock{1: 2}, clock.VectorClock{1: 1}, false},
+		{clock.VectorClock{1: 1}, clock.VectorClock{1: 1}, false},
+		{clock.VectorClock{1: 1, 2: 2}, clock.VectorClock{1: 2, 2: 1}, false},
+		{clock.VectorClock{1: 1}, clock.VectorClock{1: 1, 2: 1}, true},
+		{clock.VectorClock{1: 1}, clock.VectorClock{2: 1}, false},
+		{clock.VectorClock{1: 2}, clock.VectorClock{1: 1, 2: 1}, false},
+		{clock.VectorClock{}, clock.VectorClock{1: 1}, true},
+	} {
+		if got := tt.v.Before(tt.other); got != tt.want {
+			t.Errorf("%v.Before(%v) = %v, want %v", tt.v, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestMergeAndCovers(t *testing.T) {
+	v := clock.VectorClock{1: 2, 2: 1}
+	v.Merge(clock.VectorClock{2: 3, 3: 1})
+	want := clock.VectorClock{1: 2, 2: 3, 3: 1}
+	if !v.Covers(want) || !want.Covers(v) {
+		t.Errorf("merged clock is %v, want %v", v, want)
+	}
+	if v.Covers(clock.VectorClock{1: 3}) {
+		t.Errorf("%v covers %v", v, clock.VectorClock{1: 3})
+	}
+}
@@ -0,0 +1,60 @@
+// Package clock implements the vector clocks that order the events of the
+// CRDT.
+package clock
+
+// VectorClock is a simplified version of a vector clock,
+// where the client id and time are just simple integers.
+type VectorClock map[int]int
+
+// Before checks whether 'v' happened before 'other'.
+// It uses the definition of ordering from: https://en.wikipedia.org/wiki/Vector_clock
+// i.e. 'v' is less than 'y' if and only if 'v' is less than or equal to 'other' for all dimensions,
+// and at least one of those relationships is strictly smaller.
+func (v VectorClock) Before(other VectorClock) bool {
+	strictlySmaller := false
+
+	for id, vDT := range v {
+		if otherDT, existsInOther := other[id]; existsInOther && vDT > otherDT {
+			return false
+		} else if existsInOther && vDT < otherDT {
+			strictlySmaller = true
+		}
+	}
+
+	// variation on the algorithm: they equal in all known dimensions then
+	// use the number of dimensions as a tie-break. (we want ordering to
+	// always be deterministic).
+	if !strictlySmaller && len(v) < len(other) {
+		return true
+	}
+
+	return strictlySmaller
+}
+
+// Copy returns a copy of 'v' that can be modified independently.
+func (v VectorClock) Copy() VectorClock {
+	c := make(VectorClock, len(v))
+	for id, dt := range v {
+		c[id] = dt
+	}
+	return c
+}
+
+// Merge sets every dimension of 'v' to the max of itself and 'other'.
+func (v VectorClock) Merge(other VectorClock) {
+	for id, otherDT := range other {
+		if otherDT > v[id] {
+			v[id] = otherDT
+		}
+	}
+}
+
+// Covers checks whether 'v' has seen every event in 'other'.
+func (v VectorClock) Covers(other VectorClock) bool {
+	for id, t := range other {
+		if v[id] < t {
+			return false
+		}
+	}
+	return true
+}
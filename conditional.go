@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrChanged is returned by ApplyIfUnchanged when the node was changed
+// since it was read.
+var ErrChanged = errors.New("node changed since it was read")
+
+// Version returns the version of the node with the key, which changes every
+// time the node is moved, deleted or has its value set. It is empty if the
+// node doesn't exist.
+func (r *Replica) Version(itemKey string) VectorClock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version(itemKey)
+}
+
+// ApplyIfUnchanged generates and applies a local event like Insert, Delete or
+// Set would, but only if the version of its item is still 'expected', i.e.
+// nobody changed the item since the caller read it. Otherwise nothing is
+// applied and an error wrapping ErrChanged is returned, e.g. for the caller
+// to reload and ask the user what to do. Only the Type, ItemKey,
+// TargetItemKey and Value of the event are used.
+func (r *Replica) ApplyIfUnchanged(e Event, expected VectorClock) (Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current := r.version(e.ItemKey); !sameClock(current, expected) {
+		return Event{}, fmt.Errorf("%w: %s is at %v, expected %v", ErrChanged, e.ItemKey, current, expected)
+	}
+
+	switch e.Type {
+	case "update", "delete":
+		r.pushUndoPosition(e.ItemKey)
+	case "set":
+		r.pushUndoValue(e.ItemKey)
+	case "priority":
+		r.pushUndoPriority(e.ItemKey)
+	default:
+		return Event{}, fmt.Errorf("%w %q", ErrUnknownEventType, e.Type)
+	}
+	return r.local(Event{Type: e.Type, ItemKey: e.ItemKey, TargetItemKey: e.TargetItemKey, Value: e.Value}), nil
+}
+
+// version returns the version of the node, see Version. r.mu must be held.
+func (r *Replica) version(itemKey string) VectorClock {
+	version := VectorClock{}
+	if n, exists := r.crdt.nodes[itemKey]; exists {
+		version.Merge(n.latestVectorClock)
+		version.Merge(n.valueVectorClock)
+		version.Merge(n.rankVectorClock)
+	}
+	return version
+}
+
+// sameClock checks whether the clocks are equal, treating missing entries as
+// zero.
+func sameClock(a, b VectorClock) bool {
+	return a.Covers(b) && b.Covers(a)
+}
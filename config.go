@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables that configure flags.
+const envPrefix = "CRDT_"
+
+// loadConfig sets the flags in 'fs' that weren't given on the command line,
+// first from environment variables, then from the JSON config file named by
+// the 'configFlag' flag, if it is set. The environment variable for a flag
+// is its name in upper case, with dashes replaced by underscores, prefixed
+// by CRDT_, e.g. CRDT_TLS_CERT.
+// The config file is an object with flag names as keys, e.g.
+//
+//	{"addr": ":8080", "client-rate": 10, "debug": true}
+func loadConfig(fs *flag.FlagSet, configFlag string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok && !set[f.Name] && err == nil {
+			if err = fs.Set(f.Name, value); err != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, env, err)
+			}
+			set[f.Name] = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	file := fs.Lookup(configFlag).Value.String()
+	if file == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	// numbers are kept as they are written, as fmt.Sprint of a float64
+	// would give e.g. 1e+06 for 1000000, which integer flags reject.
+	config := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&config); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", file, err)
+	}
+
+	for name, value := range config {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q in config file %s", name, file)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid value %v for %q in config file %s: %v", value, name, file, err)
+		}
+	}
+
+	return nil
+}
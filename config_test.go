@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigNumbers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"max-nodes": 1000000, "client-rate": 0.5}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.String("config", "", "")
+	maxNodes := fs.Int("max-nodes", 0, "")
+	rate := fs.Float64("client-rate", 0, "")
+	if err := fs.Parse([]string{"-config", file}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(fs, "config"); err != nil {
+		t.Fatal(err)
+	}
+	if *maxNodes != 1000000 {
+		t.Errorf("max-nodes is %d, want 1000000", *maxNodes)
+	}
+	if *rate != 0.5 {
+		t.Errorf("client-rate is %g, want 0.5", *rate)
+	}
+}
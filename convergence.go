@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Divergence is a counterexample to convergence: the same events applied in
+// two different orders that result in different documents.
+type Divergence struct {
+	Events []Event
+	// First and Second are the two orders, as indexes into Events.
+	First, Second []int
+	// FirstResult and SecondResult are the documents the orders result in.
+	FirstResult, SecondResult string
+}
+
+func (d *Divergence) String() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "events:")
+	for i, e := range d.Events {
+		fmt.Fprintf(&b, "  %d: %s %s -> %s %q %s\n", i, e.Type, e.ItemKey, e.TargetItemKey, e.Value, canonicalClock(e.VectorClock))
+	}
+	fmt.Fprintf(&b, "order %v results in %s\n", d.First, d.FirstResult)
+	fmt.Fprintf(&b, "order %v results in %s\n", d.Second, d.SecondResult)
+	return b.String()
+}
+
+// GoString returns Go code, for this package, that reproduces the
+// divergence by applying the events in both orders and printing the
+// resulting trees.
+func (d *Divergence) GoString() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "events := []Event{")
+	for _, e := range d.Events {
+		fields := []string{fmt.Sprintf("Type: %q", e.Type)}
+		fields = append(fields, "VectorClock: VectorClock{"+goClock(e.VectorClock)+"}")
+		for _, f := range []struct{ name, value string }{{"ItemKey", e.ItemKey}, {"TargetItemKey", e.TargetItemKey}, {"Value", e.Value}} {
+			if f.value != "" {
+				fields = append(fields, fmt.Sprintf("%s: %s", f.name, goKey(f.value)))
+			}
+		}
+		fmt.Fprintf(&b, "\t{%s},\n", strings.Join(fields, ", "))
+	}
+	fmt.Fprintln(&b, "}")
+	fmt.Fprintf(&b, "for _, order := range [][]int{%s, %s} {\n", goInts(d.First), goInts(d.Second))
+	fmt.Fprintln(&b, "\tcrdt := NewCRDT()")
+	fmt.Fprintln(&b, "\tfor _, i := range order {")
+	fmt.Fprintln(&b, "\t\tcrdt.Apply(events[i])")
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b, "\tfmt.Print(Printer{}.Print(crdt))")
+	fmt.Fprintln(&b, "}")
+	return b.String()
+}
+
+// goKey returns the Go expression for a key, using the constants for the
+// internal nodes.
+func goKey(key string) string {
+	switch key {
+	case rootKey:
+		return "rootKey"
+	case ghostKey:
+		return "ghostKey"
+	}
+	return fmt.Sprintf("%q", key)
+}
+
+// goClock returns the entries of the clock as a Go map literal, without
+// the braces.
+func goClock(v VectorClock) string {
+	pairs := strings.Fields(canonicalClock(v))
+	for i, pair := range pairs {
+		pairs[i] = strings.Replace(pair, ":", ": ", 1)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func goInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = fmt.Sprint(n)
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// CheckConvergence applies the events in the order given, and then in up to
+// 'samples' other orders, checking they all result in the same document.
+// When there are no more than 'samples' orders all of them are tried,
+// otherwise they are picked at random using 'seed'. It returns nil if the
+// events converge, or the first divergence found, shrunk to a minimal
+// reproducer.
+func CheckConvergence(events []Event, samples int, seed int64) *Divergence {
+	first := make([]int, len(events))
+	for i := range first {
+		first[i] = i
+	}
+	want := applyInOrder(events, first)
+
+	check := func(order []int) *Divergence {
+		if got := applyInOrder(events, order); got != want {
+			return minimizeDivergence(&Divergence{
+				Events:       events,
+				First:        first,
+				Second:       append([]int{}, order...),
+				FirstResult:  want,
+				SecondResult: got,
+			})
+		}
+		return nil
+	}
+
+	orders, all := countOrders(len(events), samples)
+	if all {
+		for _, order := range permutations(append([]int{}, first...)) {
+			if d := check(order); d != nil {
+				return d
+			}
+		}
+		return nil
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < orders; i++ {
+		if d := check(rng.Perm(len(events))); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// countOrders returns the number of orders of 'n' events to try, and
+// whether that is all of them.
+func countOrders(n, samples int) (int, bool) {
+	total := 1
+	for i := 2; i <= n; i++ {
+		total *= i
+		if total > samples {
+			return samples, false
+		}
+	}
+	return total, true
+}
+
+// applyInOrder applies the events to a new CRDT in the order given by
+// 'order', and returns the resulting document.
+func applyInOrder(events []Event, order []int) string {
+	crdt := NewCRDT()
+	for _, i := range order {
+		crdt.Apply(events[i])
+	}
+	return document(crdt)
+}
+
+// document returns the keys of the CRDT in order, along with their values,
+// which is what has to be the same for two CRDTs to have converged.
+func document(crdt *CRDT) string {
+	keys := []string{}
+	for n := range crdt.Traverse() {
+		keys = append(keys, documentKey(n.key, n.value))
+	}
+	return strings.Join(keys, ",")
+}
+
+// documentKey formats a node of a document, with its value if it has one.
+func documentKey(key, value string) string {
+	if value != "" {
+		return fmt.Sprintf("%s=%q", key, value)
+	}
+	return key
+}
+
+// minimizeDivergence shrinks the divergence into a smaller one that still
+// diverges: it removes the events that aren't needed, shrinks their clocks,
+// and brings the second order as close to the first as it can, until none
+// of those change anything.
+func minimizeDivergence(d *Divergence) *Divergence {
+	for shrunk := true; shrunk; {
+		shrunk = false
+		for _, shrink := range []func(*Divergence) *Divergence{withoutEvent, withSmallerClock, withCloserOrder} {
+			if smaller := shrink(d); smaller != nil {
+				d, shrunk = smaller, true
+				break
+			}
+		}
+	}
+	return d
+}
+
+// diverges returns the divergence of the two orders of the events, or nil
+// if they result in the same document.
+func diverges(events []Event, first, second []int) *Divergence {
+	d := &Divergence{Events: events, First: first, Second: second}
+	d.FirstResult = applyInOrder(events, first)
+	d.SecondResult = applyInOrder(events, second)
+	if d.FirstResult == d.SecondResult {
+		return nil
+	}
+	return d
+}
+
+// withoutEvent returns the divergence with one of its events removed, or
+// nil if every event is needed.
+func withoutEvent(d *Divergence) *Divergence {
+	for i := range d.Events {
+		events := append(append([]Event{}, d.Events[:i]...), d.Events[i+1:]...)
+		remove := func(order []int) []int {
+			res := make([]int, 0, len(order)-1)
+			for _, j := range order {
+				switch {
+				case j < i:
+					res = append(res, j)
+				case j > i:
+					res = append(res, j-1)
+				}
+			}
+			return res
+		}
+
+		if smaller := diverges(events, remove(d.First), remove(d.Second)); smaller != nil {
+			return smaller
+		}
+	}
+	return nil
+}
+
+// withSmallerClock returns the divergence with an entry of one of the event
+// clocks removed or halved, or nil if none of them can be. Clocks are kept
+// distinct, since no two real events have the same clock.
+func withSmallerClock(d *Divergence) *Divergence {
+	for i, e := range d.Events {
+		for id, dt := range e.VectorClock {
+			candidates := []VectorClock{}
+			if len(e.VectorClock) > 1 {
+				c := e.VectorClock.Copy()
+				delete(c, id)
+				candidates = append(candidates, c)
+			}
+			if dt > 1 {
+				c := e.VectorClock.Copy()
+				c[id] = dt / 2
+				candidates = append(candidates, c)
+			}
+
+			for _, clock := range candidates {
+				if clockUsed(d.Events, clock) {
+					continue
+				}
+				events := append([]Event{}, d.Events...)
+				events[i].VectorClock = clock
+				if smaller := diverges(events, d.First, d.Second); smaller != nil {
+					return smaller
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// clockUsed checks whether any of the events has the clock 'v'.
+func clockUsed(events []Event, v VectorClock) bool {
+	for _, e := range events {
+		if len(e.VectorClock) == len(v) && canonicalClock(e.VectorClock) == canonicalClock(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCloserOrder returns the divergence with two adjacent events of the
+// second order swapped so that they are in the same relative order as in
+// the first, or nil if no such swap keeps them diverging.
+func withCloserOrder(d *Divergence) *Divergence {
+	position := make([]int, len(d.First))
+	for p, i := range d.First {
+		position[i] = p
+	}
+
+	for p := 0; p+1 < len(d.Second); p++ {
+		if position[d.Second[p]] < position[d.Second[p+1]] {
+			continue
+		}
+		second := append([]int{}, d.Second...)
+		second[p], second[p+1] = second[p+1], second[p]
+		if smaller := diverges(d.Events, d.First, second); smaller != nil {
+			return smaller
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// debugState is the state of a replica dumped by the debug handler.
+type debugState struct {
+	Replica int            `json:"replica"`
+	Clock   VectorClock    `json:"clock"`
+	Events  int            `json:"events"`
+	Stats   Stats          `json:"stats"`
+	Memory  MemoryEstimate `json:"memory"`
+	Lag     []Lag          `json:"lag,omitempty"`
+}
+
+// NewDebugHandler returns an http.Handler that dumps the replica's clock,
+// number of applied events, Stats, memory estimate and peers' replication
+// lag as JSON.
+func NewDebugHandler(r *Replica) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		state := debugState{
+			Replica: r.ID(),
+			Clock:   r.Clock(),
+			Events:  r.Feed().Len(),
+			Lag:     r.ReplicationLag(),
+		}
+		r.View(func(crdt *CRDT) {
+			state.Stats = crdt.Stats()
+			state.Memory = crdt.EstimateMemory()
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(state)
+	})
+}
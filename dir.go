@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dirEntry is a file or directory mirrored into a document.
+type dirEntry struct {
+	key  string
+	info fs.FileInfo
+}
+
+// DirMirror keeps a replica's document in step with a directory tree. Every
+// file and directory is a node under the node of its directory, with its
+// name as the value. Directory names end in a slash.
+type DirMirror struct {
+	r    *Replica
+	root string
+	// entries is keyed by path relative to the root.
+	entries map[string]dirEntry
+}
+
+// ImportDir builds the replica's document from the directory tree at 'path',
+// returning the mirror to keep following the directory with, and the events
+// that were generated.
+func ImportDir(r *Replica, path string) (*DirMirror, []Event, error) {
+	m := &DirMirror{r: r, root: path, entries: map[string]dirEntry{}}
+	events, err := m.Scan()
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, events, nil
+}
+
+// Scan compares the directory tree with how it was at the last scan, and
+// generates the events for the files and directories created, moved, renamed
+// or deleted since. Moves are recognised by the file being the same one on
+// disk, so a moved file keeps its node.
+func (m *DirMirror) Scan() ([]Event, error) {
+	found := map[string]fs.FileInfo{}
+	var paths []string
+	err := filepath.WalkDir(m.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == m.root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.root, path)
+		if err != nil {
+			return err
+		}
+		found[rel] = info
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning %s: %w", m.root, err)
+	}
+
+	// the entries that aren't where they were are either moved or deleted.
+	gone := map[string]dirEntry{}
+	for path, entry := range m.entries {
+		if info, exists := found[path]; !exists || !os.SameFile(info, entry.info) {
+			gone[path] = entry
+			delete(m.entries, path)
+		}
+	}
+
+	var events []Event
+	// WalkDir is in lexical order, so directories come before their contents.
+	for _, path := range paths {
+		info := found[path]
+		if _, exists := m.entries[path]; exists {
+			continue
+		}
+
+		target := rootKey
+		if parent := filepath.Dir(path); parent != "." {
+			target = m.entries[parent].key
+		}
+
+		entry := dirEntry{info: info}
+		for old, moved := range gone {
+			if os.SameFile(moved.info, info) {
+				entry.key = moved.key
+				delete(gone, old)
+				break
+			}
+		}
+		if entry.key == "" {
+			entry.key = m.r.NewKey()
+		}
+
+		// parents are placed before their contents, so a directory can't
+		// be moved under itself, but the events applied so far mustn't be
+		// lost if it is.
+		if e, err := m.r.TryInsert(entry.key, target); err == nil {
+			events = append(events, e)
+		}
+		events = append(events, m.r.Set(entry.key, dirName(info)))
+		m.entries[path] = entry
+	}
+
+	// delete the deepest entries first, deleting a node moves its children
+	// to its parent.
+	deleted := make([]string, 0, len(gone))
+	for path := range gone {
+		deleted = append(deleted, path)
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return strings.Count(deleted[i], string(filepath.Separator)) > strings.Count(deleted[j], string(filepath.Separator))
+	})
+	for _, path := range deleted {
+		events = append(events, m.r.Delete(gone[path].key))
+	}
+
+	return events, nil
+}
+
+// Watch scans the directory every 'interval' until the context is done,
+// calling 'fn' with the events of each scan that found changes.
+func (m *DirMirror) Watch(ctx context.Context, interval time.Duration, fn func([]Event)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		events, err := m.Scan()
+		if err != nil {
+			return err
+		}
+		if len(events) > 0 {
+			fn(events)
+		}
+	}
+}
+
+// dirName returns the value of the node of a file or directory.
+func dirName(info fs.FileInfo) string {
+	if info.IsDir() {
+		return info.Name() + "/"
+	}
+	return info.Name()
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	id := flags.Int("id", 1, "client id of the events")
+	interval := flags.Duration("watch", 0, "keep watching the directory, scanning at this interval")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [-id n] [-watch interval] <dir>")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	write := func(events []Event) error {
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return fmt.Errorf("writing events: %w", err)
+			}
+		}
+		return nil
+	}
+
+	m, events, err := ImportDir(NewReplica(*id), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := write(events); err != nil || *interval <= 0 {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var werr error
+	err = m.Watch(ctx, *interval, func(events []Event) {
+		if werr = write(events); werr != nil {
+			cancel()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
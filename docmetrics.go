@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// otherDoc is the doc label of the documents without a label of their own.
+const otherDoc = "_other"
+
+// DocumentMetrics gives documents their own Metrics, labelled with the
+// document, so hot documents can be told apart. Every label is a new time
+// series in Prometheus, so only the documents in the allowlist get one, and
+// only up to a limit. The rest share the Metrics labelled "_other".
+// It is safe for concurrent use.
+type DocumentMetrics struct {
+	mu    sync.Mutex
+	allow map[string]bool
+	limit int
+	docs  map[string]*Metrics
+	other *Metrics
+}
+
+// NewDocumentMetrics returns DocumentMetrics labelling up to 'limit'
+// documents, 0 for no limit, in the order their metrics are first asked
+// for. If any are given, only the documents in 'allow' are labelled.
+func NewDocumentMetrics(limit int, allow ...string) *DocumentMetrics {
+	d := &DocumentMetrics{limit: limit, docs: map[string]*Metrics{}, other: NewMetrics()}
+	if len(allow) > 0 {
+		d.allow = map[string]bool{}
+		for _, doc := range allow {
+			d.allow[doc] = true
+		}
+	}
+	return d
+}
+
+// For returns the Metrics of the document, to set on its replica.
+func (d *DocumentMetrics) For(doc string) *Metrics {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, exists := d.docs[doc]; exists {
+		return m
+	}
+	if (d.allow != nil && !d.allow[doc]) || (d.limit > 0 && len(d.docs) >= d.limit) || doc == otherDoc {
+		return d.other
+	}
+	m := NewMetrics()
+	d.docs[doc] = m
+	return m
+}
+
+// NewDocumentMetricsHandler returns an http.Handler serving the event
+// metrics of every document in the Prometheus text exposition format,
+// labelled with doc.
+func NewDocumentMetricsHandler(d *DocumentMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.mu.Lock()
+		byDoc := map[string]*Metrics{otherDoc: d.other}
+		for doc, m := range d.docs {
+			byDoc[doc] = m
+		}
+		d.mu.Unlock()
+		writeMetrics(w, byDoc)
+	})
+}
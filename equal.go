@@ -0,0 +1,77 @@
+package main
+
+import "fmt"
+
+// Equal checks whether the two CRDTs have the same document: the same
+// nodes in the same order, with the same values, priorities and clocks.
+// Tombstones and ghost nodes aren't compared, as replicas that have
+// converged can still know about different deleted or unknown items.
+func (crdt *CRDT) Equal(other *CRDT) bool {
+	return len(DiffStates(crdt, other)) == 0
+}
+
+// DiffStates explains how the documents of 'a' and 'b' differ, with one
+// line per difference, or returns nil if they are equal.
+func DiffStates(a, b *CRDT) []string {
+	var diffs []string
+
+	orderA, orderB := []*node{}, []*node{}
+	for n := range a.Traverse() {
+		orderA = append(orderA, n)
+	}
+	for n := range b.Traverse() {
+		orderB = append(orderB, n)
+	}
+
+	for i := 0; i < len(orderA) || i < len(orderB); i++ {
+		switch {
+		case i >= len(orderA):
+			diffs = append(diffs, fmt.Sprintf("position %d: only b has %s", i, orderB[i].key))
+		case i >= len(orderB):
+			diffs = append(diffs, fmt.Sprintf("position %d: only a has %s", i, orderA[i].key))
+		case orderA[i].key != orderB[i].key:
+			diffs = append(diffs, fmt.Sprintf("position %d: a has %s, b has %s", i, orderA[i].key, orderB[i].key))
+		}
+		if len(diffs) > 0 {
+			// everything after the first difference in order is usually
+			// shifted, so only the first one is useful.
+			break
+		}
+	}
+
+	inB := map[string]*node{}
+	for _, n := range orderB {
+		inB[n.key] = n
+	}
+	inA := map[string]bool{}
+	for _, na := range orderA {
+		inA[na.key] = true
+		nb, exists := inB[na.key]
+		if !exists {
+			diffs = append(diffs, fmt.Sprintf("%s: missing from b", na.key))
+			continue
+		}
+		if na.value != nb.value {
+			diffs = append(diffs, fmt.Sprintf("%s: value %q in a, %q in b", na.key, na.value, nb.value))
+		}
+		if ca, cb := canonicalClock(na.latestVectorClock), canonicalClock(nb.latestVectorClock); ca != cb {
+			diffs = append(diffs, fmt.Sprintf("%s: clock (%s) in a, (%s) in b", na.key, ca, cb))
+		}
+		if ca, cb := canonicalClock(na.valueVectorClock), canonicalClock(nb.valueVectorClock); ca != cb {
+			diffs = append(diffs, fmt.Sprintf("%s: value clock (%s) in a, (%s) in b", na.key, ca, cb))
+		}
+		if na.rank != nb.rank {
+			diffs = append(diffs, fmt.Sprintf("%s: priority %g in a, %g in b", na.key, na.rank, nb.rank))
+		}
+		if ca, cb := canonicalClock(na.rankVectorClock), canonicalClock(nb.rankVectorClock); ca != cb {
+			diffs = append(diffs, fmt.Sprintf("%s: priority clock (%s) in a, (%s) in b", na.key, ca, cb))
+		}
+	}
+	for _, nb := range orderB {
+		if !inA[nb.key] {
+			diffs = append(diffs, fmt.Sprintf("%s: missing from a", nb.key))
+		}
+	}
+
+	return diffs
+}
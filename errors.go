@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownEventType is wrapped by the errors of events whose type
+	// isn't update, delete, set or priority.
+	ErrUnknownEventType = errors.New("unknown event type")
+	// ErrStaleEvent is wrapped by the errors of events that were discarded
+	// because the replica already has a later change to their item, or
+	// moves skipped because they would make a cycle. It is expected when
+	// events are delivered more than once.
+	ErrStaleEvent = errors.New("stale event")
+	// ErrCycleRejected is wrapped by the errors of local moves that would
+	// put a node under itself or one of its descendants, see Check and
+	// TryInsert.
+	ErrCycleRejected = errors.New("cycle rejected")
+	// ErrUnauthorized is wrapped by the errors of events the replica's
+	// authorizer rejects.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrClosed is wrapped by the errors of events received by a replica
+	// after it was closed.
+	ErrClosed = errors.New("replica closed")
+)
+
+// EventError is the error of an event a replica didn't apply. It wraps the
+// reason, which errors.Is matches against the Err variables.
+type EventError struct {
+	Event Event
+	Err   error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("%s %s %s: %v", e.Event.Type, e.Event.ItemKey, canonicalClock(e.Event.VectorClock), e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// SetAuthorizer sets the function deciding whether the actor that made an
+// event, see AuditEntry, may make it, e.g. Membership.Active. Received
+// events it returns an error for are rejected by TryApply.
+func (r *Replica) SetAuthorizer(authorize func(actor int, e Event) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.authorize = authorize
+}
+
+// ApplyBatch applies the events in order, as by TryApply, carrying on past
+// the ones that aren't applied. It returns nil if they all were, or the
+// errors.Join of their EventErrors.
+func (r *Replica) ApplyBatch(events []Event) error {
+	var errs []error
+	for _, e := range events {
+		if err := r.TryApply(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkStructure returns why the event can't be applied to the document
+// at all. r.mu must be held.
+func (r *Replica) checkStructure(e Event) error {
+	switch e.Type {
+	case "priority":
+		if _, ok := parsePriority(e.Value); !ok {
+			return fmt.Errorf("%w for %s: priority %q isn't a finite number", ErrInvalidValue, e.ItemKey, e.Value)
+		}
+	case "update", "delete", "set":
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownEventType, e.Type)
+	}
+	return nil
+}
+
+// checkCycle returns why the update event can't move its item under its
+// target in the document as it is. Received events aren't checked, as the
+// replica that made one may not have had the moves making it a cycle yet:
+// every replica skips it alike when applying it, see applyMove. r.mu must
+// be held.
+func (r *Replica) checkCycle(e Event) error {
+	if e.Type != "update" {
+		return nil
+	}
+	item, exists := r.crdt.nodes[e.ItemKey]
+	target, targetExists := r.crdt.nodes[e.TargetItemKey]
+	if e.ItemKey == e.TargetItemKey || (exists && targetExists && target.within(item)) {
+		return fmt.Errorf("%w: %s is under %s", ErrCycleRejected, e.TargetItemKey, e.ItemKey)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ApplyStream applies the events read from 'in', as newline delimited JSON
+// in the format of the /events endpoint and the logs read by check and
+// watch, until it ends. It returns the number of events read, which are
+// applied as by Apply, so rejected ones are quarantined rather than stop
+// the stream.
+func (r *Replica) ApplyStream(in io.Reader) (int, error) {
+	dec := json.NewDecoder(in)
+	applied := 0
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			return applied, nil
+		} else if err != nil {
+			return applied, fmt.Errorf("reading event %d: %w", applied+1, err)
+		}
+		r.Apply(e)
+		applied++
+	}
+}
+
+// WriteEvents writes the events the replica has applied that 'since' hasn't
+// seen to 'out', as newline delimited JSON in the order they were applied,
+// so another replica can catch up by passing them to ApplyStream. With a nil
+// clock every event is written. It returns the number of events written.
+func (r *Replica) WriteEvents(out io.Writer, since VectorClock) (int, error) {
+	enc := json.NewEncoder(out)
+	written := 0
+	for _, entry := range r.Feed().Tail(r.Feed().Len()) {
+		if since.Covers(entry.Event.VectorClock) {
+			continue
+		}
+		if err := enc.Encode(entry.Event); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
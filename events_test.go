@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEventWireFormat pins down the JSON replicas exchange, which clients
+// and servers of different releases have to keep understanding.
+func TestEventWireFormat(t *testing.T) {
+	e := Event{Type: "set", VectorClock: VectorClock{1: 2, 3: 1}, ItemKey: "a", Value: "x", Metadata: map[string]string{"device": "phone"}, Timestamp: 1700000000000}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Type":"set","VectorClock":{"1":2,"3":1},"ItemKey":"a","TargetItemKey":"","Value":"x","Metadata":{"device":"phone"},"Timestamp":1700000000000}`
+	if string(b) != want {
+		t.Errorf("event encodes to %s, want %s", b, want)
+	}
+
+	// events from releases before metadata and timestamps.
+	var old Event
+	if err := json.Unmarshal([]byte(`{"Type":"update","VectorClock":{"2":1},"ItemKey":"b","TargetItemKey":"_root","Value":""}`), &old); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Event{Type: "update", VectorClock: VectorClock{2: 1}, ItemKey: "b", TargetItemKey: rootKey}); !reflect.DeepEqual(old, want) {
+		t.Errorf("old event decodes to %+v, want %+v", old, want)
+	}
+}
+
+func TestApplyStreamRoundTrip(t *testing.T) {
+	r1 := New(WithID(1))
+	r1.Insert("a", rootKey)
+	r1.Set("a", "x")
+	r1.Insert("b", "a")
+
+	var out strings.Builder
+	if _, err := r1.WriteEvents(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	r2 := New(WithID(2))
+	if _, err := r2.ApplyStream(strings.NewReader(out.String())); err != nil {
+		t.Fatal(err)
+	}
+	assertConverged(t, r1, r2)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// expiresKey is the metadata key holding when the item of an update event
+// expires, in milliseconds since the Unix epoch.
+const expiresKey = "expires"
+
+// expiry is when an item expires, and the clock of the event that set it.
+type expiry struct {
+	at    time.Time
+	clock VectorClock
+}
+
+// InsertExpiring is Insert for an item that is deleted by ExpireNodes once
+// the time 'at' has passed. Moving the item again with Insert keeps its
+// expiry.
+func (r *Replica) InsertExpiring(itemKey, targetKey string, at time.Time) Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushUndoPosition(itemKey)
+	e := Event{
+		Type:          "update",
+		ItemKey:       itemKey,
+		TargetItemKey: targetKey,
+		Metadata:      map[string]string{expiresKey: strconv.FormatInt(at.UnixMilli(), 10)},
+	}
+	for k, v := range r.metadata {
+		if k != expiresKey {
+			e.Metadata[k] = v
+		}
+	}
+	// local adds the replica's metadata to events without any of their own.
+	return r.local(e)
+}
+
+// ExpireNodes deletes the items that have expired by 'now', returning the
+// events to send to the other replicas. An item is only deleted once the
+// event giving it its expiry is stable, see StableClock, so every replica
+// knows the item and its expiry. A replica without peers, see AddPeer,
+// expires nothing. Replicas that expire the same item at once both delete
+// it, which is harmless.
+func (r *Replica) ExpireNodes(now time.Time) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.expiries))
+	for key := range r.expiries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var events []Event
+	for _, key := range keys {
+		exp := r.expiries[key]
+		if now.Before(exp.at) || !r.stable.Covers(exp.clock) {
+			continue
+		}
+		delete(r.expiries, key)
+		if n, exists := r.crdt.nodes[key]; !exists || n.parent == nil || n.parent.key == ghostKey {
+			// already deleted.
+			continue
+		}
+		r.pushUndoPosition(key)
+		events = append(events, r.local(Event{Type: "delete", ItemKey: key}))
+	}
+	return events
+}
+
+// trackExpiry records the expiry of the item of an applied update event.
+// r.mu must be held.
+func (r *Replica) trackExpiry(e Event) {
+	value, exists := e.Metadata[expiresKey]
+	if e.Type != "update" || !exists {
+		return
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return
+	}
+	// concurrent expiries are settled like the position of the item, the
+	// event applied last wins unless it is older.
+	if current, exists := r.expiries[e.ItemKey]; exists && e.VectorClock.Before(current.clock) {
+		return
+	}
+	if r.expiries == nil {
+		r.expiries = map[string]expiry{}
+	}
+	r.expiries[e.ItemKey] = expiry{at: time.UnixMilli(ms), clock: e.VectorClock}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireNodesWaitsForPeers(t *testing.T) {
+	r := New(WithID(1))
+	r.InsertExpiring("a", rootKey, time.Now().Add(-time.Second))
+
+	if events := r.ExpireNodes(time.Now()); len(events) != 0 {
+		t.Fatalf("expired %d nodes without peers, want 0", len(events))
+	}
+	r.AddPeer(2)
+	if events := r.ExpireNodes(time.Now()); len(events) != 0 {
+		t.Fatalf("expired %d nodes before the peer acknowledged the expiry, want 0", len(events))
+	}
+
+	r.Acknowledge(2, r.Clock())
+	if events := r.ExpireNodes(time.Now()); len(events) != 1 || events[0].Type != "delete" {
+		t.Fatalf("expiring once the expiry is stable gave %+v, want a delete of a", events)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// FeedEntry is an event in a Feed, along with its offset.
+type FeedEntry struct {
+	Offset int
+	Event  Event
+}
+
+// Feed is an append only log of events, where every event is given the
+// next offset, starting at 0. It is safe for concurrent use.
+type Feed struct {
+	mu     sync.Mutex
+	events []Event
+	// appended is closed, and replaced, every time an event is appended,
+	// waking up any subscribers waiting for new events.
+	appended chan struct{}
+	// closed is closed by Close, ending every subscription.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFeed returns an empty Feed.
+func NewFeed() *Feed {
+	return &Feed{
+		appended: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Close ends every subscription to the feed, closing their channels, and
+// makes new subscriptions end straight away. Events can still be appended,
+// and read with Tail.
+func (f *Feed) Close() {
+	f.closeOnce.Do(func() { close(f.closed) })
+}
+
+// Append adds the event to the end of the feed, returning its offset.
+func (f *Feed) Append(e Event) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, e)
+	close(f.appended)
+	f.appended = make(chan struct{})
+	return len(f.events) - 1
+}
+
+// Len returns the number of events in the feed, which is also the offset
+// the next event will get.
+func (f *Feed) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.events)
+}
+
+// Tail returns up to the last 'n' entries in the feed, oldest first.
+func (f *Feed) Tail(n int) []FeedEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	from := len(f.events) - n
+	if from < 0 {
+		from = 0
+	}
+	return f.entries(from)
+}
+
+// Subscribe returns a channel receiving every entry in the feed starting
+// at 'fromOffset', followed by new entries as they are appended. A consumer
+// that has processed up to offset 'n' resumes by subscribing from 'n+1'.
+// The channel is closed once 'cancel' is called, or the feed is closed.
+func (f *Feed) Subscribe(fromOffset int) (entries <-chan FeedEntry, cancel func()) {
+	if fromOffset < 0 {
+		fromOffset = 0
+	}
+
+	ch := make(chan FeedEntry)
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		defer close(ch)
+		next := fromOffset
+		for {
+			f.mu.Lock()
+			pending := f.entries(next)
+			appended := f.appended
+			f.mu.Unlock()
+
+			for _, entry := range pending {
+				select {
+				case ch <- entry:
+					next++
+				case <-done:
+					return
+				case <-f.closed:
+					return
+				}
+			}
+
+			if len(pending) == 0 {
+				select {
+				case <-appended:
+				case <-done:
+					return
+				case <-f.closed:
+					return
+				}
+			}
+		}
+	}()
+
+	return ch, func() { once.Do(func() { close(done) }) }
+}
+
+// entries returns the entries from 'offset' onwards. f.mu must be held.
+func (f *Feed) entries(offset int) []FeedEntry {
+	if offset >= len(f.events) {
+		return nil
+	}
+	entries := make([]FeedEntry, 0, len(f.events)-offset)
+	for i, e := range f.events[offset:] {
+		entries = append(entries, FeedEntry{Offset: offset + i, Event: e})
+	}
+	return entries
+}
+
+// NewFeedStream returns an http.Handler that streams the feed as newline
+// delimited JSON, starting at the 'offset' query parameter, and following
+// new entries until the client goes away. With follow=false it stops once
+// the entries already in the feed are sent.
+func NewFeedStream(f *Feed) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		offset := 0
+		if o := req.URL.Query().Get("offset"); o != "" {
+			var err error
+			if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+				http.Error(w, "invalid offset", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+
+		if req.URL.Query().Get("follow") == "false" {
+			f.mu.Lock()
+			entries := f.entries(offset)
+			f.mu.Unlock()
+			for _, entry := range entries {
+				if err := enc.Encode(entry); err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		entries, cancel := f.Subscribe(offset)
+		defer cancel()
+
+		flusher, _ := w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		for {
+			select {
+			case entry, ok := <-entries:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(entry); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			case <-req.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// rejectedEventHeader has the index of the event NewEventsHandler rejected.
+const rejectedEventHeader = "CRDT-Rejected-Event"
+
+// NewEventsHandler returns an http.Handler applying the events POSTed to it,
+// as newline delimited JSON, to the replica. This is how clients that keep
+// their own replica, e.g. the OfflineClient, send their events. The first
+// event the replica rejects fails the request with 422 Unprocessable Entity,
+// 403 Forbidden if it is unauthorized, or 503 Service Unavailable once the
+// replica is closed, with the index of the event in the CRDT-Rejected-Event
+// header. Stale events, e.g. sent again by a retry, are fine.
+// With a Quorum, events, including the ones before a rejected one, are only
+// acknowledged once enough peers have them too. They are applied first
+// though, see Quorum.
+func NewEventsHandler(r *Replica, quorum *Quorum) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var events []Event
+		dec := json.NewDecoder(bytes.NewReader(body))
+		for {
+			var e Event
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			events = append(events, e)
+		}
+		rejected, status := -1, http.StatusNoContent
+		var rejection error
+		for i, e := range events {
+			if err := r.TryApply(e); err != nil && !errors.Is(err, ErrStaleEvent) {
+				rejected, rejection = i, err
+				status = http.StatusUnprocessableEntity
+				switch {
+				case errors.Is(err, ErrUnauthorized):
+					status = http.StatusForbidden
+				case errors.Is(err, ErrClosed):
+					status = http.StatusServiceUnavailable
+				}
+				break
+			}
+		}
+
+		// events from peers are already being replicated by the peer.
+		if quorum != nil && !quorum.fromPeer(req) && rejected != 0 {
+			applied := body
+			if rejected > 0 {
+				var b bytes.Buffer
+				enc := json.NewEncoder(&b)
+				for _, e := range events[:rejected] {
+					enc.Encode(e)
+				}
+				applied = b.Bytes()
+			}
+			if err := quorum.Replicate(req.Context(), applied); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if rejected >= 0 {
+			w.Header().Set(rejectedEventHeader, strconv.Itoa(rejected))
+			http.Error(w, fmt.Sprintf("event %d rejected, the ones before it were applied: %v", rejected, rejection), status)
+			return
+		}
+		w.WriteHeader(status)
+	})
+}
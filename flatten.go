@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// FlatItem is a node of the document, flattened into a list in traversal
+// order.
+type FlatItem struct {
+	Key string `json:"key"`
+	// Depth is 0 for the children of the root, 1 for theirs, and so on.
+	Depth  int    `json:"depth"`
+	Value  string `json:"value"`
+	Cursor Cursor `json:"cursor"`
+}
+
+// Cursor is an opaque position in the flattened document. Unlike an index
+// it stays valid across edits: it points at its item wherever the item
+// moves to, and close to where the item was if it is deleted.
+type Cursor string
+
+// cursorPosition is what a Cursor encodes: the item, and the item before it
+// and its parent at the time, to fall back to if the item is deleted.
+type cursorPosition struct {
+	Key    string `json:"k"`
+	Before string `json:"b,omitempty"`
+	Parent string `json:"p,omitempty"`
+}
+
+// Flatten returns the nodes of the document in traversal order, with their
+// depth and a Cursor for each, so a list can be shown a window at a time.
+// Like Traverse, it includes the items waiting under a target that hasn't
+// arrived yet, at the depth they would have as children of the root.
+func (crdt *CRDT) Flatten() []FlatItem {
+	items := []FlatItem{}
+	for n := crdt.After(crdt.nodes[rootKey]); n != nil; n = crdt.After(n) {
+		pos := cursorPosition{Key: n.key}
+		if len(items) > 0 {
+			pos.Before = items[len(items)-1].Key
+		}
+		if n.parent.key != rootKey {
+			pos.Parent = n.parent.key
+		}
+		items = append(items, FlatItem{Key: n.key, Depth: flatDepth(n), Value: n.value, Cursor: encodeCursor(pos)})
+	}
+	return items
+}
+
+// flatDepth returns the depth of 'n' below the root, or below the
+// placeholder of the unknown target it waits under.
+func flatDepth(n *node) int {
+	depth := 0
+	for p := n.parent; p.key != rootKey && p.parent.key != ghostKey; p = p.parent {
+		depth++
+	}
+	return depth
+}
+
+// Seek returns the index in Flatten of the cursor's item. If the item has
+// been deleted, it is the index of whatever now comes after the item that
+// was before it, or failing that after its parent, or 0.
+func (crdt *CRDT) Seek(c Cursor) (int, error) {
+	pos, err := decodeCursor(c)
+	if err != nil {
+		return 0, err
+	}
+
+	index := map[string]int{}
+	for i, item := range crdt.Flatten() {
+		index[item.Key] = i
+	}
+	if i, exists := index[pos.Key]; exists {
+		return i, nil
+	}
+	for _, key := range []string{pos.Before, pos.Parent} {
+		if i, exists := index[key]; exists {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// Flatten returns the nodes of the document as a list, see CRDT.Flatten.
+func (r *Replica) Flatten() []FlatItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crdt.Flatten()
+}
+
+// Seek returns the index of the cursor in Flatten, see CRDT.Seek.
+func (r *Replica) Seek(c Cursor) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crdt.Seek(c)
+}
+
+func encodeCursor(pos cursorPosition) Cursor {
+	b, _ := json.Marshal(pos)
+	return Cursor(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func decodeCursor(c Cursor) (cursorPosition, error) {
+	var pos cursorPosition
+	b, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return pos, fmt.Errorf("invalid cursor: %v", err)
+	}
+	if err := json.Unmarshal(b, &pos); err != nil {
+		return pos, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return pos, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFlattenIncludesItemsWaitingForTheirTarget(t *testing.T) {
+	crdt := NewCRDT()
+	for _, e := range []Event{
+		{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+		{Type: "update", ItemKey: "b", TargetItemKey: "a", VectorClock: VectorClock{1: 2}},
+		// e hasn't arrived, so d and its child f wait under it.
+		{Type: "update", ItemKey: "d", TargetItemKey: "e", VectorClock: VectorClock{2: 1}},
+		{Type: "update", ItemKey: "f", TargetItemKey: "d", VectorClock: VectorClock{2: 2}},
+	} {
+		crdt.Apply(e)
+	}
+
+	var want []string
+	for n := range crdt.Traverse() {
+		want = append(want, n.key)
+	}
+	var keys []string
+	depths := map[string]int{}
+	for _, item := range crdt.Flatten() {
+		keys = append(keys, item.Key)
+		depths[item.Key] = item.Depth
+	}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("Flatten gives %v, Traverse %v", keys, want)
+	}
+	for key, depth := range map[string]int{"a": 0, "b": 1, "d": 0, "f": 1} {
+		if depths[key] != depth {
+			t.Errorf("%s has depth %d, want %d", key, depths[key], depth)
+		}
+	}
+
+	// once e arrives, cursors still find d.
+	cursor := crdt.Flatten()[slices.Index(keys, "d")].Cursor
+	crdt.Apply(Event{Type: "update", ItemKey: "e", TargetItemKey: rootKey, VectorClock: VectorClock{2: 3}})
+	i, err := crdt.Seek(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := crdt.Flatten()[i]; got.Key != "d" || got.Depth != 1 {
+		t.Errorf("cursor of d seeks to %+v", got)
+	}
+}
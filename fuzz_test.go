@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// fuzzKeys are the items fuzzed events use, few enough that they keep
+// running into each other.
+var fuzzKeys = []string{"a", "b", "c", "d", "e"}
+
+// fuzzEvents turns the data into events, four bytes each, made by three
+// actors who see some of each other's events, as real replicas would.
+func fuzzEvents(data []byte) []Event {
+	var events []Event
+	clocks := map[int]VectorClock{}
+	for ; len(data) >= 4 && len(events) < 12; data = data[4:] {
+		actor := 1 + int(data[0])%3
+		clock := clocks[actor].Copy()
+		if len(events) > 0 && data[2]%2 == 1 {
+			clock.Merge(events[int(data[2]/2)%len(events)].VectorClock)
+		}
+		clock[actor]++
+		clocks[actor] = clock
+
+		e := Event{ItemKey: fuzzKeys[int(data[1])%len(fuzzKeys)], VectorClock: clock.Copy()}
+		switch data[0] / 3 % 5 {
+		case 0, 1:
+			e.Type = "update"
+			e.TargetItemKey = rootKey
+			if i := int(data[1]) / len(fuzzKeys) % (len(fuzzKeys) + 1); i < len(fuzzKeys) {
+				e.TargetItemKey = fuzzKeys[i]
+			}
+		case 2:
+			e.Type = "delete"
+		case 3:
+			e.Type = "set"
+			e.Value = fmt.Sprint(data[3] % 4)
+		case 4:
+			e.Type = "priority"
+			e.Value = fmt.Sprint(data[3] % 4)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// assertAcyclic fails the test if following parents from any node of the
+// CRDT doesn't lead to the root.
+func assertAcyclic(t *testing.T, crdt *CRDT, events []Event) {
+	t.Helper()
+	for key, n := range crdt.nodes {
+		steps := 0
+		for p := n; p.key != rootKey; p = p.parent {
+			if p.parent == nil || steps > len(crdt.nodes) {
+				t.Fatalf("%s is detached from the root\nevents: %#v", key, events)
+			}
+			steps++
+		}
+	}
+}
+
+// FuzzApply checks that applying events never panics or breaks the tree,
+// and that the document is the same whatever order the events arrive in.
+func FuzzApply(f *testing.F) {
+	f.Add([]byte{0, 25, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0})
+	f.Add([]byte{0, 25, 0, 0, 0, 26, 0, 0, 9, 0, 0, 2, 6, 1, 0, 0})
+	f.Add([]byte{0, 25, 0, 0, 1, 1, 1, 0, 11, 0, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		events := fuzzEvents(data)
+		if d := CheckConvergence(events, 10, int64(len(data))); d != nil {
+			t.Fatalf("%v\n%#v", d, d)
+		}
+
+		// events delivered again change nothing.
+		once := applyInOrder(events, identity(len(events)))
+		if twice := applyInOrder(append(events, events...), identity(2*len(events))); twice != once {
+			t.Fatalf("applying the events twice gives %s, once gives %s\nevents: %#v", twice, once, events)
+		}
+
+		crdt := NewCRDT()
+		for _, e := range events {
+			crdt.Apply(e)
+		}
+		assertAcyclic(t, crdt, events)
+		if err := crdt.Validate(); err != nil {
+			t.Fatalf("%v\nevents: %#v", err, events)
+		}
+	})
+}
+
+// FuzzDecodeRuns checks that decoding runs never panics, that encoding the
+// events again gives the same events, and that replicas cope with them.
+func FuzzDecodeRuns(f *testing.F) {
+	f.Add([]byte(`[{"Type":"update","ItemKey":"a","TargetItemKey":"0:0","VectorClock":{"1":1},"Actor":1,"Items":["b","c"]}]`))
+	f.Add([]byte(`[{"Type":"set","ItemKey":"a","Value":"x","VectorClock":{"1":2,"2":1}},{"Type":"delete","ItemKey":"a","VectorClock":{"2":2}}]`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var runs []Run
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return
+		}
+		events := DecodeRuns(runs)
+		if again := DecodeRuns(EncodeRuns(events)); !reflect.DeepEqual(again, events) {
+			t.Fatalf("encoding and decoding %#v gives %#v", events, again)
+		}
+
+		r := NewReplica(1)
+		for _, e := range events {
+			r.Apply(e)
+		}
+	})
+}
+
+// FuzzDecodeEvent checks that decoding an event never panics, that encoding
+// it again gives the same event, and that replicas cope with it.
+func FuzzDecodeEvent(f *testing.F) {
+	f.Add([]byte(`{"Type":"update","ItemKey":"a","TargetItemKey":"_root","VectorClock":{"1":1}}`))
+	f.Add([]byte(`{"Type":"set","ItemKey":"a","Value":"x","VectorClock":{"1":2,"2":1}}`))
+	f.Add([]byte(`{"Type":"delete","ItemKey":"b","VectorClock":{"2":1}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			return
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var again Event
+		if err := json.Unmarshal(b, &again); err != nil {
+			t.Fatalf("decoding %s: %v", b, err)
+		}
+		if !reflect.DeepEqual(again, e) {
+			t.Fatalf("encoding and decoding %#v gives %#v", e, again)
+		}
+
+		r := NewReplica(1)
+		r.Insert("a", rootKey)
+		r.Apply(e)
+	})
+}
+
+func identity(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PendingGhost is an unknown target: a node that events refer to but whose
+// own update hasn't arrived. It is resolved when the update arrives, until
+// then the nodes inserted under it wait with it under the ghost node.
+type PendingGhost struct {
+	Key string `json:"key"`
+	// Since is when the replica first saw an event referring to it.
+	Since time.Time `json:"since"`
+	// Waiting are the keys of the nodes inserted under it.
+	Waiting []string `json:"waiting,omitempty"`
+}
+
+// PendingGhosts returns the unknown targets of the replica, oldest first.
+// One that stays pending for long is the sign of a lost event.
+func (r *Replica) PendingGhosts() []PendingGhost {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ghosts := []PendingGhost{}
+	for key, since := range r.ghosts {
+		n, pending := r.crdt.placeholder(key)
+		if !pending {
+			delete(r.ghosts, key)
+			continue
+		}
+		ghost := PendingGhost{Key: key, Since: since}
+		for _, c := range n.children.slice() {
+			ghost.Waiting = append(ghost.Waiting, c.key)
+		}
+		ghosts = append(ghosts, ghost)
+	}
+	sort.Slice(ghosts, func(i, j int) bool {
+		if !ghosts[i].Since.Equal(ghosts[j].Since) {
+			return ghosts[i].Since.Before(ghosts[j].Since)
+		}
+		return ghosts[i].Key < ghosts[j].Key
+	})
+	return ghosts
+}
+
+// ReapGhosts removes the unknown targets pending for longer than 'age',
+// along with every node waiting under them, and returns what was removed.
+// This only changes the local replica: if the missing events arrive after
+// all, the nodes they refer to are gone, and the replica won't converge
+// with the others. So it is for when the events are known to be lost.
+func (r *Replica) ReapGhosts(age time.Duration) []PendingGhost {
+	var reaped []PendingGhost
+	now := time.Now()
+	for _, ghost := range r.PendingGhosts() {
+		if now.Sub(ghost.Since) <= age {
+			continue
+		}
+
+		r.mu.Lock()
+		if n, pending := r.crdt.placeholder(ghost.Key); pending {
+			n.parent.children.remove(n)
+			r.crdt.forget(n)
+			r.crdt.forgetMoves()
+			delete(r.ghosts, ghost.Key)
+			reaped = append(reaped, ghost)
+		}
+		r.mu.Unlock()
+	}
+	return reaped
+}
+
+// GhostTask returns a maintenance task looking for unknown targets pending
+// for longer than 'age'. They are reaped if 'reap' is set, and reported as
+// an error either way, so they can be investigated.
+func GhostTask(interval, jitter, age time.Duration, reap bool) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "ghosts",
+		Interval: interval,
+		Jitter:   jitter,
+		Run: func(ctx context.Context, doc string, r *Replica) error {
+			var expired []PendingGhost
+			if reap {
+				expired = r.ReapGhosts(age)
+			} else {
+				for _, ghost := range r.PendingGhosts() {
+					if time.Since(ghost.Since) > age {
+						expired = append(expired, ghost)
+					}
+				}
+			}
+			if len(expired) == 0 {
+				return nil
+			}
+
+			keys := make([]string, 0, len(expired))
+			for _, ghost := range expired {
+				keys = append(keys, ghost.Key)
+			}
+			verb := "pending"
+			if reap {
+				verb = "reaped"
+			}
+			return fmt.Errorf("%d unknown targets %s for over %s, events may be lost: %s", len(expired), verb, age, strings.Join(keys, ", "))
+		},
+	}
+}
+
+// trackGhosts records when the unknown targets an event refers to were
+// first seen. r.mu must be held.
+func (r *Replica) trackGhosts(e Event, now time.Time) {
+	for _, key := range []string{e.TargetItemKey, e.ItemKey} {
+		if _, tracked := r.ghosts[key]; tracked {
+			continue
+		}
+		if _, pending := r.crdt.placeholder(key); pending {
+			if r.ghosts == nil {
+				r.ghosts = map[string]time.Time{}
+			}
+			r.ghosts[key] = now
+		}
+	}
+}
+
+// placeholder returns the node with the key if it is an unknown target,
+// i.e. under the ghost node without a clock.
+func (crdt *CRDT) placeholder(key string) (*node, bool) {
+	n, exists := crdt.nodes[key]
+	if !exists || n.parent == nil || n.parent.key != ghostKey || len(n.latestVectorClock) != 0 {
+		return nil, false
+	}
+	return n, true
+}
+
+// forget removes the node and its descendants from the index of nodes.
+func (crdt *CRDT) forget(n *node) {
+	for _, c := range n.children.slice() {
+		crdt.forget(c)
+	}
+	delete(crdt.nodes, n.key)
+}
@@ -1,5 +1,5 @@
 module github.com/dlmiddlecote/crdt
 
-go 1.17
+go 1.21
 
 require github.com/xlab/treeprint v1.1.0
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// assertConverged fails the test unless every replica has the same
+// document as the first.
+func assertConverged(t testing.TB, replicas ...*Replica) {
+	t.Helper()
+	var want string
+	for i, r := range replicas {
+		var got string
+		r.View(func(crdt *CRDT) {
+			got = Printer{ShowValues: true}.Print(crdt)
+		})
+		if i == 0 {
+			want = got
+		} else if got != want {
+			t.Fatalf("replica %d has\n%s\nreplica %d has\n%s", r.ID(), got, replicas[0].ID(), want)
+		}
+	}
+}
+
+// assertOrder fails the test unless the keys of the CRDT's nodes, in the
+// order they are traversed, are 'keys'.
+func assertOrder(t testing.TB, crdt *CRDT, keys []string) {
+	t.Helper()
+	var got []string
+	for n := range crdt.Traverse() {
+		got = append(got, n.key)
+	}
+	if strings.Join(got, ",") != strings.Join(keys, ",") {
+		t.Fatalf("order is %s, want %s", strings.Join(got, ","), strings.Join(keys, ","))
+	}
+}
+
+// assertGolden fails the test unless the CRDT, printed by the Printer, is
+// the content of testdata/golden/'name'.txt. Run the tests with -update
+// to rewrite it instead.
+func assertGolden(t testing.TB, name string, p Printer, crdt *CRDT) {
+	t.Helper()
+	got := p.Print(crdt)
+	file := filepath.Join("testdata", "golden", name+".txt")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("%s:\n%s\nwant:\n%s", name, got, want)
+	}
+}
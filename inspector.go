@@ -0,0 +1,122 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// inspectorTemplate renders the state of a replica. Nodes are rendered
+// recursively so the page mirrors the internal tree, ghost branch included.
+var inspectorTemplate = template.Must(template.New("inspector").Funcs(template.FuncMap{"age": eventAge}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>CRDT replica {{.ID}}</title>
+<style>
+body { font-family: monospace; }
+ul { list-style: none; padding-left: 1.5em; }
+.internal { color: grey; }
+.tombstone { color: firebrick; text-decoration: line-through; }
+.placeholder { color: darkorange; font-style: italic; }
+td, th { padding: 0 1em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Replica {{.ID}}</h1>
+<p>clock: {{.Clock}}</p>
+<h2>Order</h2>
+<p>{{range .Order}}{{.}} {{else}}(empty){{end}}</p>
+<h2>Tree</h2>
+<ul>{{template "node" .Root}}</ul>
+<p>
+<span class="internal">internal</span>,
+<span class="tombstone">deleted</span>,
+<span class="placeholder">unknown target (ghost)</span>
+</p>
+<h2>Recent events</h2>
+<table>
+<tr><th>type</th><th>item</th><th>target</th><th>clock</th><th>made</th></tr>
+{{range .Events}}<tr><td>{{.Type}}</td><td>{{.ItemKey}}</td><td>{{.TargetItemKey}}</td><td>{{.VectorClock}}</td><td>{{age .}}</td></tr>
+{{else}}<tr><td colspan="5">no events yet</td></tr>
+{{end}}</table>
+</body>
+</html>
+{{define "node"}}<li><span class="{{.Class}}">{{.Key}}</span> {{.Clock}}{{if .Children}}<ul>{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}</li>{{end}}
+`))
+
+type inspectorPage struct {
+	ID     int
+	Clock  VectorClock
+	Order  []string
+	Root   inspectorNode
+	Events []Event
+}
+
+type inspectorNode struct {
+	Key      string
+	Clock    VectorClock
+	Class    string
+	Children []inspectorNode
+}
+
+// NewInspector returns an http.Handler serving a page that shows the state
+// of the replica: the materialized order, the internal tree including ghost
+// and deleted nodes, the clock of every node, and the recently applied events.
+func NewInspector(r *Replica) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		page := inspectorPage{
+			ID:     r.ID(),
+			Clock:  r.Clock(),
+			Events: r.RecentEvents(),
+		}
+
+		r.View(func(crdt *CRDT) {
+			for n := range crdt.Traverse() {
+				page.Order = append(page.Order, n.key)
+			}
+			page.Root = newInspectorNode(crdt.nodes[rootKey])
+		})
+
+		// show the most recent events first.
+		for i, j := 0, len(page.Events)-1; i < j; i, j = i+1, j-1 {
+			page.Events[i], page.Events[j] = page.Events[j], page.Events[i]
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := inspectorTemplate.Execute(w, page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// eventAge returns how long ago the event was made, or nothing if it has no
+// timestamp.
+func eventAge(e Event) string {
+	if e.Timestamp == 0 {
+		return ""
+	}
+	return time.Since(e.Time()).Round(time.Second).String() + " ago"
+}
+
+func newInspectorNode(n *node) inspectorNode {
+	in := inspectorNode{
+		Key:   n.key,
+		Clock: n.latestVectorClock,
+	}
+
+	switch {
+	case n.key == rootKey || n.key == ghostKey:
+		in.Class = "internal"
+	case n.parent.key == ghostKey && len(n.latestVectorClock) == 0:
+		// ghost targets are created without a clock, deleted nodes keep
+		// the clock of their delete event.
+		in.Class = "placeholder"
+	case n.parent.key == ghostKey:
+		in.Class = "tombstone"
+	}
+
+	for _, c := range n.children.slice() {
+		in.Children = append(in.Children, newInspectorNode(c))
+	}
+	return in
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/dlmiddlecote/crdt/clock"
 	"github.com/xlab/treeprint"
 )
 
@@ -13,48 +18,52 @@ const (
 	rootKey  string = "_root"
 )
 
-// VectorClock is a simplified version of a vector clock,
-// where the client id and time are just simple integers.
-type VectorClock map[int]int
-
-// Before checks whether 'v' happened before 'other'.
-// It uses the definition of ordering from: https://en.wikipedia.org/wiki/Vector_clock
-// i.e. 'v' is less than 'y' if and only if 'v' is less than or equal to 'other' for all dimensions,
-// and at least one of those relationships is strictly smaller.
-func (v VectorClock) Before(other VectorClock) bool {
-	strictlySmaller := false
-
-	for id, vDT := range v {
-		if otherDT, existsInOther := other[id]; existsInOther && vDT > otherDT {
-			return false
-		} else if existsInOther && vDT < otherDT {
-			strictlySmaller = true
-		}
-	}
-
-	// variation on the algorithm: they equal in all known dimensions then
-	// use the number of dimensions as a tie-break. (we want ordering to
-	// always be deterministic).
-	if !strictlySmaller && len(v) < len(other) {
-		return true
-	}
+// VectorClock is the vector clock of an event, see clock.VectorClock.
+type VectorClock = clock.VectorClock
 
-	return strictlySmaller
-}
-
-// Event is an update or delete event that adds 'item' to 'target item'.
+// Event is an update or delete event that adds 'item' to 'target item',
+// or a set event that sets the value of 'item'.
 type Event struct {
-	// Type is 'update' or 'delete'.
+	// Type is 'update', 'delete' or 'set'.
 	Type string
 	// VectorClock is the VectorClock of this event.
 	VectorClock   VectorClock
 	ItemKey       string
 	TargetItemKey string
+	// Value is the new value of the item for set events.
+	Value string
+	// Metadata describes where the event came from, e.g. the device,
+	// session or request that made it. It isn't used by the CRDT.
+	Metadata map[string]string `json:",omitempty"`
+	// Timestamp is when the event was made, in milliseconds since the Unix
+	// epoch, by the clock of the replica that made it, or 0 if unknown.
+	// Clocks on different machines disagree, so it is only for showing to
+	// people and is never used for ordering.
+	Timestamp int64 `json:",omitempty"`
+}
+
+// Time returns the Timestamp of the event as a time.Time, which is the zero
+// time if the event has no timestamp.
+func (e Event) Time() time.Time {
+	if e.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(e.Timestamp)
 }
 
 // CRDT is the main CRDT structure.
 type CRDT struct {
-	nodes map[string]*node
+	nodes  map[string]*node
+	logger *slog.Logger
+	arena  *nodeArena
+	// purged is the number of tombstones removed by purge.
+	purged int
+	// ordering is how siblings are ordered.
+	ordering Ordering
+	// moves are the update and delete events applied, see applyMove, and
+	// compacted the stable clock the ones it covers were dropped at.
+	moves     []move
+	compacted VectorClock
 }
 
 func NewCRDT() *CRDT {
@@ -73,60 +82,75 @@ func NewCRDT() *CRDT {
 			rootKey:  root,
 			ghostKey: ghost,
 		},
+		logger: slog.New(discardHandler{}),
 	}
 }
 
+// SetLogger sets the logger used to report notable situations when applying
+// events, such as stale events and ghost nodes, at debug and info levels.
+func (crdt *CRDT) SetLogger(logger *slog.Logger) {
+	crdt.logger = logger
+}
+
 // Traverse returns a channel that will contain nodes in the order the
 // CRDT should be in.
 // It is implemented as a Depth First Search over the nodes, skipping the
 // root, ghost and children of ghost nodes (as an implementation detail).
+// The search steps from each node to the next using the parent and sibling
+// links, so it doesn't allocate per node.
 func (crdt *CRDT) Traverse() <-chan *node {
 	ch := make(chan *node)
 	go func() {
 		defer close(ch)
-		root := crdt.nodes[rootKey]
-		queue := []*node{root}
-		for len(queue) > 0 {
-			n := queue[0]
-			children := make([]*node, len(n.children))
-			copy(children, n.children)
-			queue = append(children, queue[1:]...)
-			if n.key == rootKey || n.key == ghostKey || n.parent.key == ghostKey {
-				continue
-			}
+		for n := crdt.After(crdt.nodes[rootKey]); n != nil; n = crdt.After(n) {
 			ch <- n
 		}
 	}()
 	return ch
 }
 
+// After returns the node that comes after 'n' in the order returned by
+// Traverse, or nil if 'n' is the last one. Passing the root returns the
+// first node.
+func (crdt *CRDT) After(n *node) *node {
+	for {
+		n = n.next()
+		if n == nil || !(n.key == rootKey || n.key == ghostKey || n.parent.key == ghostKey) {
+			return n
+		}
+	}
+}
+
 // Apply adds an Event into the CRDT.
 func (crdt *CRDT) Apply(e Event) {
-	if e.Type == "update" {
-		crdt.update(e)
-	} else {
-		crdt.delete(e)
-	}
+	crdt.apply(e)
 }
 
-func (crdt *CRDT) update(e Event) {
-	item, exists := crdt.nodes[e.ItemKey]
-	if !exists {
-		// if the item doesn't exist let's create a new node
-		// and set its vector clock to the one of the event.
-		item = crdt.newNode(e.ItemKey, e.VectorClock)
+// apply adds an Event into the CRDT, returning false if the event was
+// discarded because it happened before what the item already knows about.
+func (crdt *CRDT) apply(e Event) bool {
+	if validateApply {
+		defer crdt.mustValidate(e)
 	}
 
-	// if the event happened before the latest time the item knows
-	// about, we don't do anything
-	if e.VectorClock.Before(item.latestVectorClock) {
-		return
+	// clear the aggregates where the item is, and where it ends up.
+	crdt.clearAggregates(e.ItemKey)
+	defer crdt.clearAggregates(e.ItemKey)
+
+	switch e.Type {
+	case "set":
+		return crdt.set(e)
+	case "priority":
+		return crdt.setPriority(e)
+	default:
+		return crdt.applyMove(e)
 	}
+}
 
-	// set the latest vector clock this item knows about to be the
-	// one for this event.
-	item.latestVectorClock = e.VectorClock
-
+// update places the item of an update event under its target, returning
+// false if it was skipped because the target is the item or one of its
+// descendants, which would detach them both from the root.
+func (crdt *CRDT) update(e Event, item *node) bool {
 	target, exists := crdt.nodes[e.TargetItemKey]
 	if !exists {
 		// if the target doesn't exist, we create a 'ghost' node,
@@ -137,58 +161,120 @@ func (crdt *CRDT) update(e Event) {
 		// point in time!)
 		target = crdt.newNode(e.TargetItemKey, VectorClock{})
 		crdt.addGhostNode(target)
+		crdt.logger.Info("ghost node created for unknown target", "target", e.TargetItemKey, "item", e.ItemKey, "clock", e.VectorClock)
 	}
 
-	target.AttachChild(item)
-}
-
-func (crdt *CRDT) delete(e Event) {
-	item, exists := crdt.nodes[e.ItemKey]
-	if !exists {
-		// even if the item doesn't exist, we need to create it
-		// so that it can become a 'ghost' node, that is, one that
-		// won't be output by the traversal function (it has been deleted, then).
-		// we need this incase any nodes need to be attached to this deleted node
-		// when we receive out of order messages.
-		item = crdt.newNode(e.ItemKey, e.VectorClock)
-	}
-
-	// if the event happened before the latest time the item knows
-	// about, we don't do anything
-	if e.VectorClock.Before(item.latestVectorClock) {
-		return
+	if target.within(item) {
+		crdt.logger.Debug("cycle skipped", "item", e.ItemKey, "target", e.TargetItemKey, "clock", e.VectorClock)
+		if item.parent == nil {
+			// a new item moved under itself still needs a place, and is
+			// no different from an unknown target, whatever the order
+			// the events arrive in.
+			item.latestVectorClock = VectorClock{}
+			crdt.addGhostNode(item)
+		}
+		return false
 	}
 
 	// set the latest vector clock this item knows about to be the
-	// one for this event.
+	// one for this event. (Only once the target is in place, as the
+	// item can be amongst the ghost node's children, in the place its
+	// old clock sorts to).
 	item.latestVectorClock = e.VectorClock
+	crdt.attach(target, item)
+	return true
+}
 
+// delete moves the item of a delete event under the ghost node, returning
+// the keys of the children it handed to its parent.
+func (crdt *CRDT) delete(e Event, item *node) []string {
 	// move the children nodes of the deleted node to the parent
 	// of the deleted node, if the parent exists and the parent isn't
 	// the ghost. (We don't move if the parent is the ghost because
 	// then they'd become 'ghost' nodes, which isn't the desired behaviour).
+	var moved []string
 	if item.parent != nil && item.parent.key != ghostKey {
-		for _, c := range item.children {
-			item.parent.AttachChild(c)
+		for _, c := range item.children.slice() {
+			moved = append(moved, c.key)
+			crdt.attach(item.parent, c)
 		}
-		item.children = []*node{}
 	}
 
+	// set the latest vector clock this item knows about to be the
+	// one for this event. (Only once its children have moved, as the
+	// item is still amongst them, in the place its old clock sorts to).
+	item.latestVectorClock = e.VectorClock
+
 	crdt.addGhostNode(item)
+	return moved
+}
+
+func (crdt *CRDT) set(e Event) bool {
+	item, exists := crdt.nodes[e.ItemKey]
+	if !exists {
+		// we don't know where the item goes yet, so just like an unknown
+		// target it waits under the ghost node until its update arrives.
+		// it gets no vector clock so that the update isn't ignored.
+		item = crdt.newNode(e.ItemKey, VectorClock{})
+		crdt.addGhostNode(item)
+		crdt.logger.Debug("ghost node created for item with unknown position", "item", e.ItemKey, "clock", e.VectorClock)
+	}
+
+	// the value is a last writer wins register, separate from the vector
+	// clock used to order the item amongst its siblings.
+	if !laterWrite(e.VectorClock, item.valueVectorClock, strings.Compare(e.Value, item.value)) {
+		crdt.logger.Debug("stale event ignored", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock, "latest", item.valueVectorClock)
+		return false
+	}
+
+	item.value = e.Value
+	item.valueVectorClock = e.VectorClock
+	return true
+}
+
+// laterWrite checks whether a write to a last writer wins register, with
+// clock 'v', comes after the register's latest write, with clock 'latest'.
+// Writes are ordered by the sum of their clocks, which is larger for a
+// write than for any that happened before it, so only concurrent writes
+// can tie. Those are ordered by 'cmp', comparing the value written to the
+// register's, and then by their clocks, so all replicas pick the same one
+// whatever the order they arrive in.
+func laterWrite(v, latest VectorClock, cmp int) bool {
+	if sv, sl := clockSum(v), clockSum(latest); sv != sl {
+		return sv > sl
+	}
+	if cmp != 0 {
+		return cmp > 0
+	}
+	return canonicalClock(v) > canonicalClock(latest)
 }
 
 func (crdt *CRDT) newNode(key string, vectorClock VectorClock) *node {
-	n := &node{
-		key:               key,
-		latestVectorClock: vectorClock,
+	var n *node
+	if crdt.arena != nil {
+		n = crdt.arena.alloc()
+	} else {
+		n = &node{}
 	}
+	n.key = key
+	n.latestVectorClock = vectorClock
 	crdt.nodes[key] = n
 	return n
 }
 
+// intern returns the key of the node with key 'key', so that the same string
+// can be shared instead of keeping copies of it. If there is no such node
+// 'key' is returned.
+func (crdt *CRDT) intern(key string) string {
+	if n, exists := crdt.nodes[key]; exists {
+		return n.key
+	}
+	return key
+}
+
 func (crdt *CRDT) addGhostNode(n *node) {
 	ghost := crdt.nodes[ghostKey]
-	ghost.AttachChild(n)
+	crdt.attach(ghost, n)
 }
 
 // String implements Stringer so that we can get a nicely printable
@@ -197,7 +283,7 @@ func (crdt *CRDT) String() string {
 	var addNode func(t treeprint.Tree, n *node)
 	addNode = func(t treeprint.Tree, n *node) {
 		treeNode := t.AddBranch(fmt.Sprintf("%s (%v)", n.key, n.latestVectorClock))
-		for _, c := range n.children {
+		for _, c := range n.children.slice() {
 			addNode(treeNode, c)
 		}
 	}
@@ -209,74 +295,148 @@ func (crdt *CRDT) String() string {
 	return tree.String()
 }
 
+// discardHandler is a slog.Handler that drops every record, it is the
+// default so that logging is opt in.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
 type node struct {
 	key               string
 	parent            *node
-	children          []*node
+	children          children
 	latestVectorClock VectorClock
+	value             string
+	valueVectorClock  VectorClock
+	// rank is the priority ordering the node amongst its siblings with
+	// OrderByPriority, a last writer wins register like the value.
+	rank            float64
+	rankVectorClock VectorClock
+	// treapLinks place the node amongst its siblings.
+	treapLinks
+	// aggregate summarises the descendants, nil until asked for and when
+	// they change.
+	aggregate *Aggregate
 }
 
 // AttachChild adds the child node into the correct ordered position of the
-// parents child array, sets the parent on the child node, and removes the
-// child from the old parents child array
+// parents children, see newerThan, sets the parent on the child node, and
+// removes the child from the old parents children
 func (n *node) AttachChild(child *node) {
-	// remove this child from its old parent children array
+	n.attachChild(child, func(c *node) bool {
+		return child.newerThan(c)
+	})
+}
+
+// newerThan checks whether 'n' goes before its sibling 'other' when they
+// are ordered by clock. A clock has a larger sum than any clock that
+// happened before it, so newer nodes go first. Unlike Before, this is a
+// total order: concurrent clocks with the same sum are ordered by the
+// clocks themselves, and then by key, so siblings end up in the same order
+// on every replica, whatever order they arrive in.
+func (n *node) newerThan(other *node) bool {
+	if sn, so := clockSum(n.latestVectorClock), clockSum(other.latestVectorClock); sn != so {
+		return sn > so
+	}
+	if cn, co := canonicalClock(n.latestVectorClock), canonicalClock(other.latestVectorClock); cn != co {
+		return cn > co
+	}
+	return n.key < other.key
+}
+
+// attachChild is AttachChild, with the child going before the first of the
+// other children 'before' returns true for.
+func (n *node) attachChild(child *node, before func(c *node) bool) {
+	// remove this child from its old parent children
 	if child.parent != nil {
-		newParentChildren := make([]*node, 0)
-		for _, c := range child.parent.children {
-			if c.key != child.key {
-				newParentChildren = append(newParentChildren, c)
-			}
-		}
-		child.parent.children = newParentChildren
+		child.parent.children.remove(child)
 	}
 
 	// check whether index 0 is the ghost node or not.
-	// if it is, we will need to start our array search operation
+	// if it is, we will need to start our search operation
 	// from after the ghost so that it stays at index 0.
 	startIndex := 0
-	if len(n.children) > 0 && n.children[0].key == ghostKey {
+	if n.children.len() > 0 && n.children.at(0).key == ghostKey {
 		startIndex = 1
 	}
 
-	// Find the index where the new child should be added in to the children array
-	index := startIndex + sort.Search(len(n.children)-startIndex, func(i int) bool {
-		return n.children[i+startIndex].latestVectorClock.Before(child.latestVectorClock)
+	// Find the index where the new child should be added in to the children
+	index := startIndex + sort.Search(n.children.len()-startIndex, func(i int) bool {
+		return before(n.children.at(i + startIndex))
 	})
 
-	n.children = insert(n.children, index, child)
+	n.children.insertAt(index, child)
 
 	child.parent = n
 }
 
+// next returns the node after 'n' in a depth first search of the whole tree,
+// or nil if there isn't one.
+func (n *node) next() *node {
+	if first := n.children.first(); first != nil {
+		return first
+	}
+	for ; n.parent != nil; n = n.parent {
+		if sibling := n.nextSibling(); sibling != nil {
+			return sibling
+		}
+	}
+	return nil
+}
+
 func (n *node) String() string {
-	return fmt.Sprintf("Node{key: %s, lvc: %d, children: %v}", n.key, n.latestVectorClock, n.children)
+	return fmt.Sprintf("Node{key: %s, lvc: %d, children: %v}", n.key, n.latestVectorClock, n.children.slice())
 }
 
-// insert inserts the node at the index of the array.
-func insert(a []*node, index int, value *node) []*node {
-	if len(a) == index { // nil or empty slice or after last element
-		return append(a, value)
+func main() {
+	if platformMain() {
+		return
 	}
-	a = append(a[:index+1], a[index:]...) // index < len(a)
-	a[index] = value
-	return a
+
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "repl":
+			err = runREPL(os.Args[2:])
+		case "serve":
+			err = runServe(os.Args[2:])
+		case "watch":
+			err = runWatch(os.Args[2:])
+		case "check":
+			err = runCheck(os.Args[2:])
+		case "simulate":
+			err = runSimulate(os.Args[2:])
+		case "vectors":
+			err = runVectors(os.Args[2:])
+		case "workload":
+			err = runWorkload(os.Args[2:])
+		case "import":
+			err = runImport(os.Args[2:])
+		case "replay":
+			err = runReplay(os.Args[2:])
+		case "bisect":
+			err = runBisect(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	experiment()
 }
 
-func main() {
+// experiment applies a set of events in every possible order and prints
+// the resulting orderings, which should all be the same.
+func experiment() {
 	// Create a set of events to happen.
-	events := map[int]Event{
-		1:  {Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
-		2:  {Type: "update", ItemKey: "b", TargetItemKey: "a", VectorClock: VectorClock{1: 2}},
-		3:  {Type: "update", ItemKey: "c", TargetItemKey: "b", VectorClock: VectorClock{1: 3}},
-		4:  {Type: "delete", ItemKey: "b", VectorClock: VectorClock{1: 4}},
-		5:  {Type: "update", ItemKey: "c", TargetItemKey: "a", VectorClock: VectorClock{1: 5}}, // This is a client generate event so that c stays after a when the middle 'b' is deleted.
-		6:  {Type: "update", ItemKey: "d", TargetItemKey: "c", VectorClock: VectorClock{1: 6}},
-		7:  {Type: "update", ItemKey: "f", TargetItemKey: "c", VectorClock: VectorClock{1: 6, 2: 1}},
-		8:  {Type: "update", ItemKey: "b", TargetItemKey: "a", VectorClock: VectorClock{1: 6, 2: 2}},
-		9:  {Type: "update", ItemKey: "h", TargetItemKey: rootKey, VectorClock: VectorClock{1: 8}},
-		10: {Type: "delete", ItemKey: "f", VectorClock: VectorClock{1: 9, 2: 3}},
-	}
+	events := experimentEvents()
 
 	results := map[string][][]int{}
 
@@ -323,6 +483,23 @@ func main() {
 	}
 }
 
+// experimentEvents returns the events used by the experiment, keyed by the
+// order they were made in, starting at 1.
+func experimentEvents() map[int]Event {
+	return map[int]Event{
+		1:  {Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+		2:  {Type: "update", ItemKey: "b", TargetItemKey: "a", VectorClock: VectorClock{1: 2}},
+		3:  {Type: "update", ItemKey: "c", TargetItemKey: "b", VectorClock: VectorClock{1: 3}},
+		4:  {Type: "delete", ItemKey: "b", VectorClock: VectorClock{1: 4}},
+		5:  {Type: "update", ItemKey: "c", TargetItemKey: "a", VectorClock: VectorClock{1: 5}}, // This is a client generate event so that c stays after a when the middle 'b' is deleted.
+		6:  {Type: "update", ItemKey: "d", TargetItemKey: "c", VectorClock: VectorClock{1: 6}},
+		7:  {Type: "update", ItemKey: "f", TargetItemKey: "c", VectorClock: VectorClock{1: 6, 2: 1}},
+		8:  {Type: "update", ItemKey: "b", TargetItemKey: "a", VectorClock: VectorClock{1: 6, 2: 2}},
+		9:  {Type: "update", ItemKey: "h", TargetItemKey: rootKey, VectorClock: VectorClock{1: 8}},
+		10: {Type: "delete", ItemKey: "f", VectorClock: VectorClock{1: 9, 2: 3}},
+	}
+}
+
 // permutations is a helper function that returns all permutations
 // of the input array
 func permutations(arr []int) [][]int {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchmarkTree returns a CRDT with 'size' nodes, all children of the root
+// if 'wide', or each the child of the one before otherwise.
+func benchmarkTree(size int, wide bool) *CRDT {
+	crdt := NewCRDT()
+	parent := crdt.nodes[rootKey]
+	for i := 0; i < size; i++ {
+		n := crdt.newNode(fmt.Sprint(i), VectorClock{1: i + 1})
+		parent.AttachChild(n)
+		if !wide {
+			parent = n
+		}
+	}
+	return crdt
+}
+
+func BenchmarkTraverse(b *testing.B) {
+	for _, tree := range []struct {
+		name string
+		wide bool
+	}{{"wide", true}, {"deep", false}} {
+		crdt := benchmarkTree(1000000, tree.wide)
+		b.Run(fmt.Sprintf("%s/nodes=1000000", tree.name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				count := 0
+				for range crdt.Traverse() {
+					count++
+				}
+				if count != 1000000 {
+					b.Fatalf("traversed %d nodes, want 1000000", count)
+				}
+			}
+		})
+	}
+}
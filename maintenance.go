@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// MaintenanceTask is a job run on every document of a Pipeline on a
+// schedule, e.g. collecting tombstones or checking the document is valid.
+type MaintenanceTask struct {
+	Name string
+	// Interval is the time between runs.
+	Interval time.Duration
+	// Jitter is the most extra time, chosen at random, added to each
+	// interval, so that tasks of many servers don't all run at once.
+	Jitter time.Duration
+	Run    func(ctx context.Context, doc string, r *Replica) error
+}
+
+// Maintenance runs MaintenanceTasks on the documents of a Pipeline. Only
+// one task runs on a document at a time, and applications can hold off the
+// tasks from a document with Lock.
+type Maintenance struct {
+	p     *Pipeline
+	tasks []MaintenanceTask
+	// onError, if set, is called with the errors returned by tasks.
+	onError func(task, doc string, err error)
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewMaintenance returns a Maintenance running the tasks on the documents
+// of the pipeline once started with Run. Errors returned by the tasks are
+// passed to 'onError', if it isn't nil.
+func NewMaintenance(p *Pipeline, onError func(task, doc string, err error), tasks ...MaintenanceTask) *Maintenance {
+	return &Maintenance{
+		p:       p,
+		tasks:   tasks,
+		onError: onError,
+		locks:   map[string]*sync.Mutex{},
+	}
+}
+
+// Run runs every task on its schedule until the context is done, then waits
+// for the running tasks to finish.
+func (m *Maintenance) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, task := range m.tasks {
+		wg.Add(1)
+		go func(task MaintenanceTask) {
+			defer wg.Done()
+			m.schedule(ctx, task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+// Lock stops tasks from running on the document, e.g. while it is being
+// exported, until the returned function is called.
+func (m *Maintenance) Lock(doc string) (unlock func()) {
+	m.mu.Lock()
+	l, exists := m.locks[doc]
+	if !exists {
+		l = &sync.Mutex{}
+		m.locks[doc] = l
+	}
+	m.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (m *Maintenance) schedule(ctx context.Context, task MaintenanceTask) {
+	for {
+		wait := task.Interval
+		if task.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(task.Jitter)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		for _, doc := range m.p.Docs() {
+			if ctx.Err() != nil {
+				return
+			}
+			unlock := m.Lock(doc)
+			err := task.Run(ctx, doc, m.p.Replica(doc))
+			unlock()
+			if err != nil && m.onError != nil {
+				m.onError(task.Name, doc, err)
+			}
+		}
+	}
+}
+
+// ValidateTask returns a task checking every document with Validate, to
+// find corrupted documents before users do.
+func ValidateTask(interval, jitter time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "validate",
+		Interval: interval,
+		Jitter:   jitter,
+		Run: func(ctx context.Context, doc string, r *Replica) error {
+			var err error
+			r.View(func(crdt *CRDT) {
+				err = crdt.Validate()
+			})
+			return err
+		},
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// epochBits is the number of low bits of a member id holding its epoch.
+	epochBits = 16
+	// memberKeyPrefix prefixes the keys of the members' nodes.
+	memberKeyPrefix = "member."
+	// memberActive and memberRetired are the values of the members' nodes.
+	memberActive  = "active"
+	memberRetired = "retired"
+)
+
+// MemberID returns the client id a device uses in the given epoch. A device
+// that loses its state, and with it its counters, rejoins in a new epoch so
+// it never reuses a counter of its old id.
+func MemberID(device, epoch int) int {
+	return device<<epochBits | epoch
+}
+
+// MemberDevice returns the device and epoch of a client id made by MemberID.
+func MemberDevice(id int) (device, epoch int) {
+	return id >> epochBits, id & (1<<epochBits - 1)
+}
+
+// Membership is the replicated list of the client ids that have joined a
+// document, and which of them have been retired. Like the ActorRegistry it
+// is stored in a replica of its own, where every member is a node under the
+// root whose value is "active" or "retired".
+// It is safe for concurrent use.
+type Membership struct {
+	mu sync.Mutex
+	r  *Replica
+}
+
+// NewMembership returns an empty Membership. It has to be brought up to date
+// with the other replicas' events, using Apply, before joining.
+func NewMembership() *Membership {
+	// until it joins it only applies events, so never uses its id.
+	return &Membership{r: NewReplica(0)}
+}
+
+// Join makes the device a member in the epoch after any it has been in, and
+// returns its new client id along with the events to send to the other
+// replicas of the membership. The previous ids of the device are retired.
+func (m *Membership) Join(device int) (int, []Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	epoch := 1
+	var previous []int
+	for id := range m.members() {
+		if d, e := MemberDevice(id); d == device {
+			previous = append(previous, id)
+			if e >= epoch {
+				epoch = e + 1
+			}
+		}
+	}
+	id := MemberID(device, epoch)
+
+	// carry on from everything already applied, with the new id.
+	var applied []Event
+	for _, entry := range m.r.Feed().Tail(m.r.Feed().Len()) {
+		applied = append(applied, entry.Event)
+	}
+	m.r = RestoreReplica(id, applied)
+
+	key := memberKeyPrefix + strconv.Itoa(id)
+	events := []Event{m.r.Insert(key, rootKey), m.r.Set(key, memberActive)}
+	for _, old := range previous {
+		events = append(events, m.r.Set(memberKeyPrefix+strconv.Itoa(old), memberRetired))
+	}
+	return id, events
+}
+
+// Leave retires the id, e.g. of a device that was lost, returning the events
+// to send to the other replicas. The membership must have joined.
+func (m *Membership) Leave(id int) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return []Event{m.r.Set(memberKeyPrefix+strconv.Itoa(id), memberRetired)}
+}
+
+// Apply applies an event from another replica of the membership.
+func (m *Membership) Apply(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.r.Apply(e)
+}
+
+// Active returns whether the id has joined and hasn't been retired.
+func (m *Membership) Active(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.members()[id] == memberActive
+}
+
+// Retired returns whether the id has been retired. It can be used as the
+// ClockGuard's Retired function.
+func (m *Membership) Retired(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.members()[id] == memberRetired
+}
+
+// members returns the state of every member by id. m.mu must be held.
+func (m *Membership) members() map[int]string {
+	members := map[int]string{}
+	m.r.View(func(crdt *CRDT) {
+		for n := range crdt.Traverse() {
+			id, err := strconv.Atoi(strings.TrimPrefix(n.key, memberKeyPrefix))
+			if err == nil && strings.HasPrefix(n.key, memberKeyPrefix) {
+				members[id] = n.value
+			}
+		}
+	})
+	return members
+}
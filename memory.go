@@ -0,0 +1,68 @@
+package main
+
+import "unsafe"
+
+const (
+	// mapHeaderSize is the approximate size of the header of a map.
+	mapHeaderSize = 48
+	// mapBucketSize is the number of entries in a map bucket.
+	mapBucketSize = 8
+)
+
+// MemoryEstimate is the approximate number of bytes used by a CRDT, split by
+// what they are used for.
+type MemoryEstimate struct {
+	// Nodes is the memory used by the nodes, including their keys and values.
+	Nodes int
+	// Clocks is the memory used by the vector clocks of the nodes.
+	Clocks int
+	// Index is the memory used by the map of keys to nodes.
+	Index int
+	// Moves is the memory used by the log of update and delete events kept
+	// to reorder them, see applyMove.
+	Moves int
+}
+
+// Total returns the total number of bytes.
+func (m MemoryEstimate) Total() int {
+	return m.Nodes + m.Clocks + m.Index + m.Moves
+}
+
+// EstimateMemory walks the internal structures of the CRDT and estimates the
+// number of bytes they use. It doesn't account for memory shared with the
+// events that were applied, or allocator overhead, so it is only a guide
+// for capacity planning.
+func (crdt *CRDT) EstimateMemory() MemoryEstimate {
+	var m MemoryEstimate
+
+	for _, n := range crdt.nodes {
+		m.Nodes += int(unsafe.Sizeof(*n)) + len(n.key) + len(n.value)
+		m.Clocks += estimateMapMemory(len(n.latestVectorClock), int(unsafe.Sizeof(0)*2))
+		m.Clocks += estimateMapMemory(len(n.valueVectorClock), int(unsafe.Sizeof(0)*2))
+		m.Clocks += estimateMapMemory(len(n.rankVectorClock), int(unsafe.Sizeof(0)*2))
+	}
+
+	// the keys of the index share their memory with the node keys, so only
+	// the string headers and pointers are counted.
+	m.Index = estimateMapMemory(len(crdt.nodes), int(unsafe.Sizeof("")+unsafe.Sizeof(&node{})))
+
+	// the events' keys and clocks are shared with the nodes.
+	m.Moves = cap(crdt.moves) * int(unsafe.Sizeof(move{}))
+	for _, mv := range crdt.moves {
+		m.Moves += cap(mv.moved) * int(unsafe.Sizeof(""))
+	}
+
+	return m
+}
+
+// estimateMapMemory estimates the memory used by a map with 'entries' entries,
+// each taking 'entrySize' bytes for the key and value.
+func estimateMapMemory(entries, entrySize int) int {
+	if entries == 0 {
+		return 0
+	}
+	// maps are grown before they fill up, so assume buckets are on average
+	// 3/4 full, and each entry has an extra byte of metadata.
+	buckets := (entries*4/3)/mapBucketSize + 1
+	return mapHeaderSize + buckets*mapBucketSize*(entrySize+1)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// applyDurationBuckets are the upper bounds, in seconds, of the apply
+// latency histogram buckets.
+var applyDurationBuckets = []float64{0.000001, 0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+
+// Metrics counts what happens to the events applied to a replica. It is
+// safe for concurrent use.
+type Metrics struct {
+	mu sync.Mutex
+	// applied and discarded are the number of events by type that were
+	// applied, or discarded because they were out of date.
+	applied   map[string]uint64
+	discarded map[string]uint64
+	// applyDurations counts apply latencies per bucket, with the last
+	// element counting the ones larger than every bucket.
+	applyDurations   []uint64
+	applyDurationSum float64
+}
+
+// NewMetrics returns Metrics with every count at zero.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		applied:        map[string]uint64{},
+		discarded:      map[string]uint64{},
+		applyDurations: make([]uint64, len(applyDurationBuckets)+1),
+	}
+}
+
+// observeApply records that event 'e' took 'd' to apply, and whether it was
+// applied or discarded.
+func (m *Metrics) observeApply(e Event, applied bool, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// anything that isn't an update, set or priority is applied as a delete.
+	typ := e.Type
+	if typ != "update" && typ != "set" && typ != "priority" {
+		typ = "delete"
+	}
+	if applied {
+		m.applied[typ]++
+	} else {
+		m.discarded[typ]++
+	}
+
+	seconds := d.Seconds()
+	m.applyDurations[sort.SearchFloat64s(applyDurationBuckets, seconds)]++
+	m.applyDurationSum += seconds
+}
+
+// NewMetricsHandler returns an http.Handler that serves the replica's metrics
+// in the Prometheus text exposition format. The node counts are always
+// served, the event counts only if the replica has Metrics set.
+func NewMetricsHandler(r *Replica) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		var stats Stats
+		r.View(func(crdt *CRDT) {
+			stats = crdt.Stats()
+		})
+		metricsGauge(w, "crdt_nodes", "Nodes in the document.", stats.Nodes)
+		metricsGauge(w, "crdt_tombstones", "Deleted nodes kept under the ghost node.", stats.Tombstones)
+		fmt.Fprintf(w, "# HELP crdt_purged_total Tombstones removed once stable.\n# TYPE crdt_purged_total counter\ncrdt_purged_total %d\n", stats.Purged)
+		metricsGauge(w, "crdt_ghosts", "Unknown target nodes kept under the ghost node.", stats.Ghosts)
+		oldest := 0
+		if ghosts := r.PendingGhosts(); len(ghosts) > 0 {
+			oldest = int(time.Since(ghosts[0].Since).Seconds())
+		}
+		metricsGauge(w, "crdt_ghost_oldest_seconds", "Age of the oldest unknown target, a sign of lost events.", oldest)
+
+		if lags := r.ReplicationLag(); len(lags) > 0 {
+			fmt.Fprintln(w, "# HELP crdt_replication_lag_events Events a peer hasn't acknowledged.")
+			fmt.Fprintln(w, "# TYPE crdt_replication_lag_events gauge")
+			for _, lag := range lags {
+				fmt.Fprintf(w, "crdt_replication_lag_events{peer=\"%d\"} %d\n", lag.Peer, lag.Events)
+			}
+			fmt.Fprintln(w, "# HELP crdt_replication_ack_age_seconds Time since a peer last acknowledged.")
+			fmt.Fprintln(w, "# TYPE crdt_replication_ack_age_seconds gauge")
+			for _, lag := range lags {
+				fmt.Fprintf(w, "crdt_replication_ack_age_seconds{peer=\"%d\"} %g\n", lag.Peer, lag.Since.Seconds())
+			}
+		}
+
+		if m := r.Metrics(); m != nil {
+			m.writeTo(w)
+		}
+	})
+}
+
+func (m *Metrics) writeTo(w io.Writer) {
+	writeMetrics(w, map[string]*Metrics{"": m})
+}
+
+// metricsSnapshot is a copy of the counts of Metrics.
+type metricsSnapshot struct {
+	applied          map[string]uint64
+	discarded        map[string]uint64
+	applyDurations   []uint64
+	applyDurationSum float64
+}
+
+// snapshot returns a copy of the counts, so that they can be written
+// without holding the lock.
+func (m *Metrics) snapshot() metricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := metricsSnapshot{
+		applied:          make(map[string]uint64, len(m.applied)),
+		discarded:        make(map[string]uint64, len(m.discarded)),
+		applyDurations:   append([]uint64(nil), m.applyDurations...),
+		applyDurationSum: m.applyDurationSum,
+	}
+	for typ, n := range m.applied {
+		s.applied[typ] = n
+	}
+	for typ, n := range m.discarded {
+		s.discarded[typ] = n
+	}
+	return s
+}
+
+// writeMetrics writes the event metrics of every document, with a doc label
+// for all but the "" document, so that each metric is only described once.
+// Each document's Metrics are copied under their own lock, one at a time.
+func writeMetrics(w io.Writer, byDoc map[string]*Metrics) {
+	docs := make([]string, 0, len(byDoc))
+	snapshots := make(map[string]metricsSnapshot, len(byDoc))
+	for doc, m := range byDoc {
+		docs = append(docs, doc)
+		snapshots[doc] = m.snapshot()
+	}
+	sort.Strings(docs)
+	labels := func(doc, extra string) string {
+		var l []string
+		if doc != "" {
+			l = append(l, fmt.Sprintf("doc=%q", doc))
+		}
+		if extra != "" {
+			l = append(l, extra)
+		}
+		if len(l) == 0 {
+			return ""
+		}
+		return "{" + strings.Join(l, ",") + "}"
+	}
+
+	for _, counter := range []struct {
+		name, help string
+		byType     func(metricsSnapshot) map[string]uint64
+	}{
+		{"crdt_events_applied_total", "Events applied, by type.", func(m metricsSnapshot) map[string]uint64 { return m.applied }},
+		{"crdt_events_discarded_total", "Events discarded because they were out of date, by type.", func(m metricsSnapshot) map[string]uint64 { return m.discarded }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		for _, doc := range docs {
+			for _, typ := range []string{"update", "delete", "set", "priority"} {
+				fmt.Fprintf(w, "%s%s %d\n", counter.name, labels(doc, fmt.Sprintf("type=%q", typ)), counter.byType(snapshots[doc])[typ])
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP crdt_apply_duration_seconds Time taken to apply an event.")
+	fmt.Fprintln(w, "# TYPE crdt_apply_duration_seconds histogram")
+	for _, doc := range docs {
+		m := snapshots[doc]
+		var count uint64
+		for i, le := range applyDurationBuckets {
+			count += m.applyDurations[i]
+			fmt.Fprintf(w, "crdt_apply_duration_seconds_bucket%s %d\n", labels(doc, fmt.Sprintf("le=\"%g\"", le)), count)
+		}
+		count += m.applyDurations[len(applyDurationBuckets)]
+		fmt.Fprintf(w, "crdt_apply_duration_seconds_bucket%s %d\n", labels(doc, `le="+Inf"`), count)
+		fmt.Fprintf(w, "crdt_apply_duration_seconds_sum%s %g\n", labels(doc, ""), m.applyDurationSum)
+		fmt.Fprintf(w, "crdt_apply_duration_seconds_count%s %d\n", labels(doc, ""), count)
+	}
+}
+
+func metricsGauge(w io.Writer, name, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
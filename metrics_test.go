@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDocumentMetricsConcurrentScrapes(t *testing.T) {
+	d := NewDocumentMetrics(0)
+	for _, doc := range []string{"a", "b", "c", "d"} {
+		d.For(doc)
+	}
+	h := NewDocumentMetricsHandler(d)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				d.For("b").observeApply(Event{Type: "update"}, true, time.Microsecond)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scrapes didn't finish, deadlocked?")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if want := `crdt_events_applied_total{doc="b",type="update"} 400`; !strings.Contains(string(body), want) {
+		t.Errorf("metrics don't have %s:\n%s", want, body)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"sort"
+)
+
+// move is an update or delete event applied to the CRDT, with what it
+// changed, so that it can be undone.
+type move struct {
+	event Event
+	// sum is the clockSum of the event's clock.
+	sum int
+	// parent is the key of the item's parent before the event, "" if it
+	// had none, and clock its latest vector clock.
+	parent string
+	clock  VectorClock
+	// moved are the keys of the children a delete handed to the item's
+	// parent.
+	moved []string
+	// skipped is set for events that weren't applied, because they happened
+	// before the item's latest change, or would have moved it under itself.
+	skipped bool
+}
+
+// applyMove applies an update or delete event. The CRDT keeps every one it
+// has applied in a single order, see moveBefore, that all replicas agree
+// on, and the tree is always the result of applying them in that order: an
+// event that comes before ones already applied undoes them, is applied,
+// and then they are applied again. So concurrent moves of the same item
+// end with the last in that order, and moves that would make a cycle, e.g.
+// 'a' under 'b' and 'b' under 'a' made concurrently, are skipped by every
+// replica in the same way, however the events arrive.
+//
+// Only the events that aren't stable yet are kept, see compactMoves, as
+// every event still to arrive comes after the stable ones. An event for an
+// item the CRDT doesn't know yet is applied straight away, without undoing
+// the ones after it, unless one of them deletes its target: none of them
+// can have moved the item, or anything under it.
+//
+// It returns false if the event was already applied, or if the item's
+// place comes from another event, because this one was skipped or a later
+// change to the item overrides it.
+func (crdt *CRDT) applyMove(e Event) bool {
+	if len(crdt.compacted) > 0 && crdt.compacted.Covers(e.VectorClock) {
+		crdt.logger.Debug("duplicate event ignored", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock)
+		return false
+	}
+
+	// events mostly arrive in order, after every one already applied.
+	sum := clockSum(e.VectorClock)
+	i := len(crdt.moves)
+	if i > 0 && !crdt.moves[i-1].before(e, sum) {
+		i = sort.Search(len(crdt.moves), func(i int) bool {
+			return !crdt.moves[i].before(e, sum)
+		})
+	}
+	if i < len(crdt.moves) && sameMove(crdt.moves[i].event, e) {
+		crdt.logger.Debug("duplicate event ignored", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock)
+		return false
+	}
+
+	if _, exists := crdt.nodes[e.ItemKey]; !exists && !crdt.deletedAfter(i, e.TargetItemKey) {
+		crdt.moves = append(crdt.moves, move{})
+		copy(crdt.moves[i+1:], crdt.moves[i:])
+		crdt.moves[i] = crdt.doMove(e, sum)
+		return !crdt.moves[i].skipped
+	}
+
+	for j := len(crdt.moves) - 1; j >= i; j-- {
+		crdt.undoMove(crdt.moves[j])
+	}
+	crdt.moves = append(crdt.moves, move{})
+	copy(crdt.moves[i+1:], crdt.moves[i:])
+	crdt.moves[i] = crdt.doMove(e, sum)
+	if i == len(crdt.moves)-1 {
+		return !crdt.moves[i].skipped
+	}
+
+	for j := i + 1; j < len(crdt.moves); j++ {
+		crdt.moves[j] = crdt.doMove(crdt.moves[j].event, crdt.moves[j].sum)
+	}
+	crdt.logger.Debug("later events reapplied", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock, "reapplied", len(crdt.moves)-1-i)
+	item := crdt.nodes[e.ItemKey]
+	return !crdt.moves[i].skipped && sameClock(item.latestVectorClock, e.VectorClock)
+}
+
+// doMove applies the update or delete event, whose clockSum is 'sum', to
+// the tree, returning how to undo it.
+func (crdt *CRDT) doMove(e Event, sum int) move {
+	m := move{event: e, sum: sum}
+	item, exists := crdt.nodes[e.ItemKey]
+	if !exists {
+		// if the item doesn't exist let's create a new node
+		// and set its vector clock to the one of the event.
+		item = crdt.newNode(e.ItemKey, e.VectorClock)
+	} else {
+		m.clock = item.latestVectorClock
+		if item.parent != nil {
+			m.parent = item.parent.key
+		}
+	}
+
+	// if the event happened before the latest time the item knows
+	// about, we don't do anything
+	if e.VectorClock.Before(item.latestVectorClock) {
+		crdt.logger.Debug("stale event ignored", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock, "latest", item.latestVectorClock)
+		m.skipped = true
+		return m
+	}
+
+	crdt.clearAggregates(item.key)
+	defer crdt.clearAggregates(item.key)
+	item.aggregate = nil
+
+	if e.Type == "update" {
+		m.skipped = !crdt.update(e, item)
+	} else {
+		m.moved = crdt.delete(e, item)
+	}
+	return m
+}
+
+// undoMove puts the item of the event back where it was before it, and the
+// children a delete moved back under it.
+func (crdt *CRDT) undoMove(m move) {
+	item, exists := crdt.nodes[m.event.ItemKey]
+	if !exists {
+		// the item has been reaped or purged since.
+		return
+	}
+	crdt.clearAggregates(item.key)
+	defer crdt.clearAggregates(item.key)
+	item.aggregate = nil
+
+	item.latestVectorClock = m.clock
+	switch parent, exists := crdt.nodes[m.parent]; {
+	case m.parent == "":
+		if item.parent != nil {
+			item.parent.children.remove(item)
+			item.parent = nil
+		}
+	case !exists:
+		// the parent has been purged since, which only happens to
+		// deleted nodes.
+		crdt.addGhostNode(item)
+	default:
+		crdt.attach(parent, item)
+	}
+	for _, key := range m.moved {
+		if c, exists := crdt.nodes[key]; exists {
+			crdt.attach(item, c)
+		}
+	}
+}
+
+// deletedAfter checks whether any of the events from the 'i'th on deletes
+// the item 'key'.
+func (crdt *CRDT) deletedAfter(i int, key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, m := range crdt.moves[i:] {
+		if m.event.Type == "delete" && m.event.ItemKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// compactMoves drops the events covered by the stable clock, see
+// StableClock. Every event still to arrive happened after them, and so
+// comes after them in the order of events, so they are never undone again.
+// Any of them delivered again is ignored.
+func (crdt *CRDT) compactMoves(stable VectorClock) {
+	moves := crdt.moves[:0]
+	for _, m := range crdt.moves {
+		if !stable.Covers(m.event.VectorClock) {
+			moves = append(moves, m)
+		}
+	}
+	clear(crdt.moves[len(moves):])
+	crdt.moves = moves
+	crdt.compacted = stable.Copy()
+}
+
+// forgetMoves drops the events of the items that are no longer in the index
+// of nodes, which can't be undone, and the moves under them, which would
+// bring them back as unknown targets if they were applied again.
+func (crdt *CRDT) forgetMoves() {
+	moves := crdt.moves[:0]
+	for _, m := range crdt.moves {
+		_, exists := crdt.nodes[m.event.ItemKey]
+		_, targetExists := crdt.nodes[m.event.TargetItemKey]
+		if exists && (m.event.Type != "update" || targetExists) {
+			moves = append(moves, m)
+		}
+	}
+	clear(crdt.moves[len(moves):])
+	crdt.moves = moves
+}
+
+// moveBefore orders update and delete events. An event that happened
+// before another comes first, as the sum of its clock is smaller, and
+// concurrent events are ordered by their clocks and then their items.
+func moveBefore(a, b Event) bool {
+	if sa, sb := clockSum(a.VectorClock), clockSum(b.VectorClock); sa != sb {
+		return sa < sb
+	}
+	if ca, cb := canonicalClock(a.VectorClock), canonicalClock(b.VectorClock); ca != cb {
+		return ca < cb
+	}
+	if a.ItemKey != b.ItemKey {
+		return a.ItemKey < b.ItemKey
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return a.TargetItemKey < b.TargetItemKey
+}
+
+// before is moveBefore for the event of the move and 'e', whose clockSum
+// is 'sum'.
+func (m move) before(e Event, sum int) bool {
+	if m.sum != sum {
+		return m.sum < sum
+	}
+	return moveBefore(m.event, e)
+}
+
+// sameMove checks whether 'a' and 'b' are the same event.
+func sameMove(a, b Event) bool {
+	return a.Type == b.Type && a.ItemKey == b.ItemKey && a.TargetItemKey == b.TargetItemKey && sameClock(a.VectorClock, b.VectorClock)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReceivedMoveMakingACycleIsSkipped(t *testing.T) {
+	r := New(WithID(1))
+	r.Insert("a", rootKey)
+	r.Insert("b", rootKey)
+	r.Insert("b", "a")
+
+	// replica 2 moved a under b before it had b under a.
+	e := Event{Type: "update", ItemKey: "a", TargetItemKey: "b", VectorClock: VectorClock{1: 2, 2: 2}}
+	if err := r.TryApply(e); !errors.Is(err, ErrStaleEvent) {
+		t.Errorf("TryApply gave %v, want %v", err, ErrStaleEvent)
+	}
+	r.View(func(crdt *CRDT) {
+		if err := crdt.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		assertOrder(t, crdt, []string{"a", "b"})
+	})
+
+	// local moves making a cycle are rejected before they become events.
+	if err := r.Check(Event{Type: "update", ItemKey: "a", TargetItemKey: "b"}); !errors.Is(err, ErrCycleRejected) {
+		t.Errorf("Check gave %v, want %v", err, ErrCycleRejected)
+	}
+}
+
+func TestConcurrentMovesMakingACycle(t *testing.T) {
+	r1, r2 := New(WithID(1)), New(WithID(2))
+	for _, e := range []Event{r1.Insert("a", rootKey), r1.Insert("b", rootKey)} {
+		r2.Apply(e)
+	}
+
+	// each move is fine on its own, together they would make a cycle.
+	e1 := r1.Insert("a", "b")
+	e2 := r2.Insert("b", "a")
+	r1.Apply(e2)
+	r2.Apply(e1)
+	assertConverged(t, r1, r2)
+
+	for _, r := range []*Replica{r1, r2} {
+		r.View(func(crdt *CRDT) {
+			if err := crdt.Validate(); err != nil {
+				t.Fatalf("replica %d: %v", r.ID(), err)
+			}
+			// both moves have the same clock sum, and e2's clock sorts
+			// first, so e1 is the one that would make the cycle.
+			if got := crdt.nodes["a"].parent.key; got != rootKey {
+				t.Errorf("replica %d: a is under %s, want %s", r.ID(), got, rootKey)
+			}
+			if got := crdt.nodes["b"].parent.key; got != "a" {
+				t.Errorf("replica %d: b is under %s, want a", r.ID(), got)
+			}
+		})
+	}
+}
+
+func TestMovesConvergeWhateverTheOrder(t *testing.T) {
+	events := []Event{
+		{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+		{Type: "update", ItemKey: "b", TargetItemKey: rootKey, VectorClock: VectorClock{1: 2}},
+		{Type: "update", ItemKey: "c", TargetItemKey: rootKey, VectorClock: VectorClock{1: 3}},
+		{Type: "update", ItemKey: "a", TargetItemKey: "b", VectorClock: VectorClock{1: 4}},
+		{Type: "update", ItemKey: "b", TargetItemKey: "c", VectorClock: VectorClock{1: 3, 2: 1}},
+		{Type: "update", ItemKey: "c", TargetItemKey: "a", VectorClock: VectorClock{1: 3, 3: 1}},
+		{Type: "delete", ItemKey: "b", VectorClock: VectorClock{1: 3, 2: 2}},
+	}
+
+	var want string
+	for _, order := range permutations([]int{0, 1, 2, 3, 4, 5, 6}) {
+		crdt := NewCRDT()
+		for _, i := range order {
+			crdt.Apply(events[i])
+		}
+		if err := crdt.Validate(); err != nil {
+			t.Fatalf("order %v: %v", order, err)
+		}
+
+		parents := map[string]string{}
+		for _, key := range []string{"a", "b", "c"} {
+			parents[key] = crdt.nodes[key].parent.key
+		}
+		if got := fmt.Sprint(parents); want == "" {
+			want = got
+		} else if got != want {
+			t.Fatalf("order %v: parents %s, want %s", order, got, want)
+		}
+	}
+}
+
+func TestMoveUnderItselfConvergesWhateverTheOrder(t *testing.T) {
+	events := []Event{
+		{Type: "update", ItemKey: "d", TargetItemKey: "d", VectorClock: VectorClock{1: 1}},
+		{Type: "update", ItemKey: "e", TargetItemKey: "d", VectorClock: VectorClock{1: 2}},
+		{Type: "update", ItemKey: "a", TargetItemKey: "b", VectorClock: VectorClock{1: 3}},
+	}
+
+	// by priority, so that d and b, which have no clock, are ordered too.
+	var want string
+	for _, order := range permutations([]int{0, 1, 2}) {
+		crdt := NewCRDT()
+		crdt.SetOrdering(OrderByPriority)
+		for _, i := range order {
+			crdt.Apply(events[i])
+		}
+		if got := document(crdt); want == "" {
+			want = got
+		} else if got != want {
+			t.Fatalf("order %v gives %s, want %s", order, got, want)
+		}
+	}
+}
+
+func TestStableMovesAreCompacted(t *testing.T) {
+	r := New(WithID(1))
+	r.AddPeer(2)
+	events := []Event{r.Insert("a", rootKey), r.Insert("b", rootKey), r.Insert("a", "b")}
+	r.Acknowledge(2, events[1].VectorClock)
+
+	r.View(func(crdt *CRDT) {
+		if len(crdt.moves) != 1 {
+			t.Fatalf("%d moves kept, want the one the peer hasn't acknowledged", len(crdt.moves))
+		}
+	})
+	// delivering them again changes nothing.
+	for _, e := range events {
+		if err := r.TryApply(e); !errors.Is(err, ErrStaleEvent) {
+			t.Errorf("delivering %s again gave %v, want %v", e.ItemKey, err, ErrStaleEvent)
+		}
+	}
+	r.View(func(crdt *CRDT) {
+		assertOrder(t, crdt, []string{"b", "a"})
+	})
+}
+
+// BenchmarkLateEvents applies 200 events from a peer concurrent with the
+// last 500 of the events already applied, which the peer hasn't
+// acknowledged: inserts of new items, and moves of items every replica
+// has.
+func BenchmarkLateEvents(b *testing.B) {
+	const late, unacknowledged = 200, 500
+	for _, kind := range []string{"inserts", "moves"} {
+		for _, nodes := range []int{1000, 10000, 50000} {
+			b.Run(fmt.Sprintf("%s/nodes=%d", kind, nodes), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					r := New(WithID(1))
+					for j := 1; j <= nodes; j++ {
+						r.Apply(Event{Type: "update", ItemKey: fmt.Sprint(j), TargetItemKey: rootKey, VectorClock: VectorClock{1: j}})
+					}
+					r.AddPeer(2)
+					r.Acknowledge(2, VectorClock{1: nodes - unacknowledged})
+					b.StartTimer()
+
+					for j := 1; j <= late; j++ {
+						e := Event{Type: "update", ItemKey: fmt.Sprintf("late%d", j), TargetItemKey: rootKey, VectorClock: VectorClock{1: nodes - unacknowledged, 2: j}}
+						if kind == "moves" {
+							e.ItemKey, e.TargetItemKey = fmt.Sprint(j+1), "1"
+						}
+						r.Apply(e)
+					}
+				}
+			})
+		}
+	}
+}
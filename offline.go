@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// offlineState is what an OfflineClient persists.
+type offlineState struct {
+	// Offset is the offset of the next entry to read from the server's feed.
+	Offset int `json:"offset"`
+	// Applied are the events applied to the replica, local or from the
+	// server, to restore it from.
+	Applied []Event `json:"applied"`
+	// Pending are the local events the server hasn't accepted yet.
+	Pending []Event `json:"pending"`
+	// Rejected are the local events the server rejected.
+	Rejected []RejectedEvent `json:"rejected,omitempty"`
+}
+
+// RejectedEvent is a local event the server rejected, and why. It stays
+// applied to the client's replica.
+type RejectedEvent struct {
+	Event  Event  `json:"event"`
+	Reason string `json:"reason"`
+}
+
+// OfflineClient edits a local replica whether or not the server is
+// reachable. Local events are queued, and the replica and queue are saved
+// to a file so they survive restarts. Sync sends the queue to the server's
+// /events and applies what the server's /feed has that the replica doesn't.
+// The whole file is rewritten on every change, so it suits the documents
+// of a single user rather than large ones.
+// It is safe for concurrent use.
+type OfflineClient struct {
+	r      *Replica
+	server string
+	file   string
+	client *http.Client
+
+	mu    sync.Mutex
+	state offlineState
+}
+
+// NewOfflineClient returns a client generating events as client 'id', and
+// syncing with the server at the base URL 'server'. Its state is kept in
+// 'file', and the replica is restored from it if it exists.
+func NewOfflineClient(id int, server, file string) (*OfflineClient, error) {
+	c := &OfflineClient{server: server, file: file, client: http.DefaultClient}
+
+	b, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &c.state); err != nil {
+			return nil, fmt.Errorf("invalid queue file %s: %v", file, err)
+		}
+	}
+	c.r = RestoreReplica(id, c.state.Applied)
+	return c, nil
+}
+
+// Replica returns the client's replica, which must only be changed through
+// the client.
+func (c *OfflineClient) Replica() *Replica {
+	return c.r
+}
+
+// Insert places 'itemKey' under 'targetKey', see Replica.TryInsert.
+func (c *OfflineClient) Insert(itemKey, targetKey string) error {
+	e, err := c.r.TryInsert(itemKey, targetKey)
+	if err != nil {
+		return err
+	}
+	return c.queue(e)
+}
+
+// Delete deletes 'itemKey', see Replica.Delete.
+func (c *OfflineClient) Delete(itemKey string) error {
+	return c.queue(c.r.Delete(itemKey))
+}
+
+// Set sets the value of 'itemKey', see Replica.Set.
+func (c *OfflineClient) Set(itemKey, value string) error {
+	return c.queue(c.r.Set(itemKey, value))
+}
+
+// Session returns a session token covering everything the client has
+// written or read, for reads made outside the client, e.g. with the resource
+// API, to wait until a replica has caught up with it.
+func (c *OfflineClient) Session() string {
+	return EncodeSessionToken(c.r.Clock())
+}
+
+// Pending returns the number of events waiting to be sent.
+func (c *OfflineClient) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.state.Pending)
+}
+
+// Rejected returns the local events the server has rejected, oldest first.
+func (c *OfflineClient) Rejected() []RejectedEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]RejectedEvent(nil), c.state.Rejected...)
+}
+
+// ClearRejected forgets the events the server has rejected.
+func (c *OfflineClient) ClearRejected() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Rejected = nil
+	return c.save()
+}
+
+// Sync sends the queued events to the server, then applies the events in
+// the server's feed that haven't been read yet. Events stay queued until
+// the server accepts them, so a failed Sync can simply be retried. Events
+// the server rejects, e.g. with an invalid value, are moved from the queue
+// to Rejected, so they don't hold up the ones after them.
+func (c *OfflineClient) Sync(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.state.Pending) > 0 {
+		rejected, reason, err := c.post(ctx, c.state.Pending)
+		if err != nil {
+			return err
+		}
+		if rejected < 0 {
+			c.state.Pending = nil
+		} else {
+			// the events before the rejected one were applied.
+			c.state.Rejected = append(c.state.Rejected, RejectedEvent{Event: c.state.Pending[rejected], Reason: reason})
+			c.state.Pending = append([]Event(nil), c.state.Pending[rejected+1:]...)
+		}
+		if err := c.save(); err != nil {
+			return err
+		}
+	}
+
+	// the feed has the events sent above too, applying them again doesn't
+	// change anything.
+	resp, err := c.do(ctx, http.MethodGet, "/feed?follow=false&offset="+strconv.Itoa(c.state.Offset), nil)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(resp))
+	for {
+		var entry FeedEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading feed: %w", err)
+		}
+		c.r.Apply(entry.Event)
+		c.state.Applied = append(c.state.Applied, entry.Event)
+		c.state.Offset = entry.Offset + 1
+	}
+	return c.save()
+}
+
+// Run syncs every 'interval' until the context is done, carrying on through
+// errors, e.g. while offline. Errors are passed to 'onError' if it isn't nil.
+func (c *OfflineClient) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Sync(ctx); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queue adds a local event to the queue and saves it.
+func (c *OfflineClient) queue(e Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Applied = append(c.state.Applied, e)
+	c.state.Pending = append(c.state.Pending, e)
+	return c.save()
+}
+
+// save writes the state to the queue file, replacing it atomically so a
+// crash doesn't lose the queue. c.mu must be held.
+func (c *OfflineClient) save() error {
+	b, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// post sends events to the server's /events. If the server rejects one
+// for good, it returns the event's index and why, and -1 otherwise.
+func (c *OfflineClient) post(ctx context.Context, events []Event) (int, string, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		enc.Encode(e)
+	}
+	resp, b, err := c.send(ctx, http.MethodPost, "/events", &body)
+	if err != nil {
+		return -1, "", err
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity || resp.StatusCode == http.StatusForbidden {
+		if i, err := strconv.Atoi(resp.Header.Get(rejectedEventHeader)); err == nil && i >= 0 && i < len(events) {
+			return i, string(bytes.TrimSpace(b)), nil
+		}
+	}
+	if resp.StatusCode/100 != 2 {
+		return -1, "", fmt.Errorf("POST /events: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return -1, "", nil
+}
+
+// do makes a request to the server, returning the response body.
+func (c *OfflineClient) do(ctx context.Context, method, path string, body io.Reader) ([]byte, error) {
+	resp, b, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
+
+// send makes a request to the server, returning the response and its body
+// whatever the status.
+func (c *OfflineClient) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set(versionHeader, strconv.Itoa(wireVersion))
+	req.Header.Set(sessionHeader, c.Session())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, b, nil
+}
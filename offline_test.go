@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncMovesRejectedEventsAside(t *testing.T) {
+	server := New(WithID(1), WithValueValidator(func(itemKey, value string) error {
+		if value == "bad" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/feed", NewFeedStream(server.Feed()))
+	mux.Handle("/events", NewEventsHandler(server, nil))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "queue.json")
+	c, err := NewOfflineClient(2, ts.URL, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("a", rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("a", "bad"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("a", "good"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := c.Pending(); n != 0 {
+		t.Errorf("%d events still pending", n)
+	}
+	rejected := c.Rejected()
+	if len(rejected) != 1 || rejected[0].Event.Value != "bad" || !strings.Contains(rejected[0].Reason, "not allowed") {
+		t.Fatalf("rejected events are %+v, want the bad value", rejected)
+	}
+	server.View(func(crdt *CRDT) {
+		if got := crdt.nodes["a"].value; got != "good" {
+			t.Errorf("server has value %q, want the event after the rejected one applied", got)
+		}
+	})
+
+	// the rejected events survive a restart until they are cleared.
+	restarted, err := NewOfflineClient(2, ts.URL, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.Rejected(); len(got) != 1 {
+		t.Fatalf("restarted client has rejected events %+v", got)
+	}
+	if err := restarted.ClearRejected(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.Rejected(); len(got) != 0 {
+		t.Errorf("cleared client has rejected events %+v", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Option configures a replica made by New.
+type Option func(*options)
+
+type options struct {
+	id     int
+	events []Event
+	setup  []func(*Replica)
+}
+
+// New returns a Replica configured by the options, generating events as
+// client 1 unless WithID says otherwise. Every option has a setter on
+// Replica, or CRDT, that can change it later.
+func New(opts ...Option) *Replica {
+	o := options{id: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r := NewReplica(o.id)
+	for _, setup := range o.setup {
+		setup(r)
+	}
+	// the events come last, so the other options apply to them, e.g. the
+	// metrics count them.
+	r.restore(o.events)
+	return r
+}
+
+// WithID sets the client id the replica generates events as.
+func WithID(id int) Option {
+	return func(o *options) { o.id = id }
+}
+
+// WithEvents restores the replica from the events it had applied, see
+// RestoreReplica.
+func WithEvents(events []Event) Option {
+	return func(o *options) { o.events = append(o.events, events...) }
+}
+
+// WithLogger sets the logger, see Replica.SetLogger.
+func WithLogger(logger *slog.Logger) Option {
+	return withSetup(func(r *Replica) { r.SetLogger(logger) })
+}
+
+// WithMetrics sets the metrics, see Replica.SetMetrics.
+func WithMetrics(m *Metrics) Option {
+	return withSetup(func(r *Replica) { r.SetMetrics(m) })
+}
+
+// WithArena allocates nodes in blocks, see CRDT.SetArena.
+func WithArena(size int) Option {
+	return withSetup(func(r *Replica) { r.crdt.SetArena(size) })
+}
+
+// WithAudit sets the audit log, see Replica.SetAudit.
+func WithAudit(a *AuditLog) Option {
+	return withSetup(func(r *Replica) { r.SetAudit(a) })
+}
+
+// WithMetadata sets the metadata of local events, see Replica.SetMetadata.
+func WithMetadata(metadata map[string]string) Option {
+	return withSetup(func(r *Replica) { r.SetMetadata(metadata) })
+}
+
+// WithTimestamps timestamps local events, see Replica.SetTimestamps.
+func WithTimestamps() Option {
+	return withSetup(func(r *Replica) { r.SetTimestamps(true) })
+}
+
+// WithClockGuard sets the clock guard, see Replica.SetClockGuard.
+func WithClockGuard(g *ClockGuard) Option {
+	return withSetup(func(r *Replica) { r.SetClockGuard(g) })
+}
+
+// WithQuota sets the quota, see Replica.SetQuota.
+func WithQuota(q Quota) Option {
+	return withSetup(func(r *Replica) { r.SetQuota(q) })
+}
+
+// WithValueValidator sets the value validator, see
+// Replica.SetValueValidator.
+func WithValueValidator(validator func(itemKey, value string) error) Option {
+	return withSetup(func(r *Replica) { r.SetValueValidator(validator) })
+}
+
+// WithAuthorizer sets the authorizer, see Replica.SetAuthorizer.
+func WithAuthorizer(authorize func(actor int, e Event) error) Option {
+	return withSetup(func(r *Replica) { r.SetAuthorizer(authorize) })
+}
+
+// WithWarnings sets the warning thresholds, see Replica.SetWarnings.
+func WithWarnings(w Warnings) Option {
+	return withSetup(func(r *Replica) { r.SetWarnings(w) })
+}
+
+// WithBatching broadcasts local events in batches, see Replica.SetBatching.
+func WithBatching(window time.Duration, send func(batch []byte)) Option {
+	return withSetup(func(r *Replica) { r.SetBatching(window, send) })
+}
+
+// WithOrdering sets how siblings are ordered, see CRDT.SetOrdering.
+func WithOrdering(o Ordering) Option {
+	return withSetup(func(r *Replica) { r.SetOrdering(o) })
+}
+
+// WithStableHandler sets the stable clock handler, see
+// Replica.SetStableHandler.
+func WithStableHandler(fn func(stable VectorClock)) Option {
+	return withSetup(func(r *Replica) { r.SetStableHandler(fn) })
+}
+
+func withSetup(setup func(*Replica)) Option {
+	return func(o *options) { o.setup = append(o.setup, setup) }
+}
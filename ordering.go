@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Ordering is how the children of a node are ordered. Every replica of a
+// document has to use the same one.
+type Ordering int
+
+const (
+	// OrderByClock puts the newest children first, the default. Children
+	// made concurrently are ordered by their clocks, and then by key, so
+	// every replica agrees.
+	OrderByClock Ordering = iota
+	// OrderByPriority puts the children with the lowest priority, see
+	// Replica.SetPriority, first, e.g. for boards where users rank the
+	// items. Children with the same priority are ordered newest first, by
+	// the sum of their clocks, and then by key, so every replica agrees.
+	// Children without a priority have priority 0.
+	OrderByPriority
+)
+
+// SetOrdering sets how siblings are ordered, reordering the children of
+// every node.
+func (crdt *CRDT) SetOrdering(o Ordering) {
+	crdt.ordering = o
+	for _, n := range crdt.nodes {
+		// attach finds a child's place by binary search, which needs the
+		// other children in order already, so they are sorted all at once.
+		kids := n.children.slice()
+		start := 0
+		if len(kids) > 0 && kids[0].key == ghostKey {
+			start = 1
+		}
+		sort.SliceStable(kids[start:], func(i, j int) bool {
+			return crdt.sortsBefore(kids[start+i], kids[start+j])
+		})
+		n.children = children{}
+		for i, c := range kids {
+			n.children.insertAt(i, c)
+		}
+	}
+}
+
+// SetOrdering sets how siblings are ordered, see CRDT.SetOrdering.
+func (r *Replica) SetOrdering(o Ordering) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.crdt.SetOrdering(o)
+}
+
+// SetPriority generates and applies an event setting the priority of
+// 'itemKey', which orders it amongst its siblings with OrderByPriority. It
+// returns an error wrapping ErrInvalidValue, and no event, if the priority
+// is NaN or infinite, as they don't order.
+func (r *Replica) SetPriority(itemKey string, priority float64) (Event, error) {
+	if math.IsNaN(priority) || math.IsInf(priority, 0) {
+		return Event{}, fmt.Errorf("%w for %s: priority %v isn't a finite number", ErrInvalidValue, itemKey, priority)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushUndoPriority(itemKey)
+	return r.local(Event{Type: "priority", ItemKey: itemKey, Value: strconv.FormatFloat(priority, 'g', -1, 64)}), nil
+}
+
+// pushUndoPriority remembers the current priority of 'itemKey' so that the
+// next local operation setting it can be reversed.
+func (r *Replica) pushUndoPriority(itemKey string) {
+	undo := Event{Type: "priority", ItemKey: itemKey, Value: "0"}
+	if n, exists := r.crdt.nodes[itemKey]; exists {
+		undo.Value = strconv.FormatFloat(n.rank, 'g', -1, 64)
+	}
+	r.undo = append(r.undo, undo)
+}
+
+// parsePriority parses the value of a priority event, returning false if it
+// isn't a finite number.
+func parsePriority(value string) (float64, bool) {
+	priority, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(priority) || math.IsInf(priority, 0) {
+		return 0, false
+	}
+	return priority, true
+}
+
+// setPriority applies a priority event, returning false if it was discarded.
+func (crdt *CRDT) setPriority(e Event) bool {
+	priority, ok := parsePriority(e.Value)
+	if !ok {
+		crdt.logger.Warn("priority event with invalid priority ignored", "item", e.ItemKey, "clock", e.VectorClock, "priority", e.Value)
+		return false
+	}
+
+	item, exists := crdt.nodes[e.ItemKey]
+	if !exists {
+		// like a set event, it waits under the ghost node until the
+		// item's update arrives.
+		item = crdt.newNode(e.ItemKey, VectorClock{})
+		crdt.addGhostNode(item)
+		crdt.logger.Debug("ghost node created for item with unknown position", "item", e.ItemKey, "clock", e.VectorClock)
+	}
+
+	// the priority is a last writer wins register like the value, see
+	// laterWrite.
+	if !laterWrite(e.VectorClock, item.rankVectorClock, cmp.Compare(priority, item.rank)) {
+		crdt.logger.Debug("stale event ignored", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock, "latest", item.rankVectorClock)
+		return false
+	}
+
+	item.rank = priority
+	item.rankVectorClock = e.VectorClock
+	if item.parent != nil && crdt.ordering == OrderByPriority {
+		crdt.attach(item.parent, item)
+	}
+	return true
+}
+
+// attach makes 'child' a child of 'parent', in its place in the CRDT's
+// ordering.
+func (crdt *CRDT) attach(parent, child *node) {
+	if crdt.ordering == OrderByClock {
+		parent.AttachChild(child)
+		return
+	}
+	parent.attachChild(child, func(c *node) bool {
+		return crdt.sortsBefore(child, c)
+	})
+}
+
+// sortsBefore checks whether 'a' goes before its sibling 'b'.
+func (crdt *CRDT) sortsBefore(a, b *node) bool {
+	if crdt.ordering == OrderByPriority {
+		// unlike Before, this is a total order, so concurrent siblings
+		// can't end up in different orders on different replicas.
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if sa, sb := clockSum(a.latestVectorClock), clockSum(b.latestVectorClock); sa != sb {
+			return sa > sb
+		}
+		return a.key < b.key
+	}
+	return a.newerThan(b)
+}
+
+// clockSum returns the sum of the times in the clock, which is larger for
+// a clock than for any clock that happened before it.
+func clockSum(v VectorClock) int {
+	sum := 0
+	for _, t := range v {
+		sum += t
+	}
+	return sum
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSetOrderingSortsChildren(t *testing.T) {
+	for seed := int64(1); seed <= 200; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+
+		late := New(WithID(1))
+		var events []Event
+		for i := 0; i < 8; i++ {
+			key := late.NewKey()
+			insert := late.Insert(key, rootKey)
+			priority, err := late.SetPriority(key, float64(rng.Intn(100)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, insert, priority)
+		}
+		late.SetOrdering(OrderByPriority)
+
+		early := New(WithID(2), WithOrdering(OrderByPriority))
+		for _, e := range events {
+			early.Apply(e)
+		}
+
+		var got, want string
+		late.View(func(crdt *CRDT) {
+			if err := crdt.Validate(); err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+			got = document(crdt)
+		})
+		early.View(func(crdt *CRDT) {
+			want = document(crdt)
+		})
+		if got != want {
+			t.Fatalf("seed %d: switching ordering gives %s, ordering by priority from the start gives %s", seed, got, want)
+		}
+	}
+}
+
+func TestPrioritiesConvergeWhateverTheOrder(t *testing.T) {
+	events := []Event{
+		{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+		{Type: "priority", ItemKey: "a", Value: "1", VectorClock: VectorClock{3: 2}},
+		{Type: "priority", ItemKey: "a", Value: "0", VectorClock: VectorClock{1: 5, 3: 1}},
+		// happened after the first priority, concurrently with the second.
+		{Type: "priority", ItemKey: "a", Value: "0", VectorClock: VectorClock{1: 3, 3: 4}},
+	}
+
+	for _, order := range permutations([]int{0, 1, 2, 3}) {
+		crdt := NewCRDT()
+		for _, i := range order {
+			crdt.Apply(events[i])
+		}
+		if got := crdt.nodes["a"].rank; got != 0 {
+			t.Errorf("order %v: priority is %g, want 0", order, got)
+		}
+	}
+}
+
+func TestPrioritiesInStatsMemoryAndDiffs(t *testing.T) {
+	a, b := New(WithID(1)), New(WithID(2))
+	a.Apply(b.Insert("x", rootKey))
+
+	var before Stats
+	var beforeClocks int
+	a.View(func(crdt *CRDT) {
+		before, beforeClocks = crdt.Stats(), crdt.EstimateMemory().Clocks
+	})
+	// b only has the first of a's priorities.
+	e, err := a.SetPriority("x", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Apply(e)
+	if _, err := a.SetPriority("x", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	a.View(func(crdt *CRDT) {
+		if got := crdt.Stats(); got.ClockEntries <= before.ClockEntries || got.Actors != 2 {
+			t.Errorf("stats are %+v with a priority, %+v without", got, before)
+		}
+		if got := crdt.EstimateMemory().Clocks; got <= beforeClocks {
+			t.Errorf("clocks use %d bytes with a priority, %d without", got, beforeClocks)
+		}
+		b.View(func(other *CRDT) {
+			if diffs := DiffStates(crdt, other); len(diffs) != 2 {
+				t.Errorf("diffs are %q, want the priority and its clock", diffs)
+			}
+		})
+	})
+}
+
+func TestNonFinitePrioritiesAreRejected(t *testing.T) {
+	events := []Event{
+		{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+		{Type: "update", ItemKey: "b", TargetItemKey: rootKey, VectorClock: VectorClock{1: 2}},
+		{Type: "priority", ItemKey: "a", Value: "1", VectorClock: VectorClock{1: 3}},
+		{Type: "priority", ItemKey: "a", Value: "NaN", VectorClock: VectorClock{2: 5}},
+		{Type: "priority", ItemKey: "b", Value: "2", VectorClock: VectorClock{1: 4}},
+		{Type: "priority", ItemKey: "b", Value: "-Inf", VectorClock: VectorClock{3: 9}},
+	}
+
+	// replicas receiving them anyway, e.g. from an older release, ignore
+	// them, whatever order they arrive in.
+	for _, order := range permutations([]int{0, 1, 2, 3, 4, 5}) {
+		crdt := NewCRDT()
+		crdt.SetOrdering(OrderByPriority)
+		for _, i := range order {
+			crdt.Apply(events[i])
+		}
+		assertOrder(t, crdt, []string{"a", "b"})
+	}
+
+	r := New(WithID(1), WithOrdering(OrderByPriority))
+	for _, e := range []Event{events[3], events[5]} {
+		if err := r.TryApply(e); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("TryApply of priority %s gave %v, want %v", e.Value, err, ErrInvalidValue)
+		}
+	}
+	for _, priority := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := r.SetPriority("a", priority); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("SetPriority(%v) gave %v, want %v", priority, err, ErrInvalidValue)
+		}
+	}
+}
+
+func TestConcurrentSiblingsConvergeWhateverTheOrder(t *testing.T) {
+	// neither of a and b happened before the other, and they have the same
+	// number of entries, so Before doesn't order them.
+	events := []Event{
+		{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 2}},
+		{Type: "update", ItemKey: "b", TargetItemKey: rootKey, VectorClock: VectorClock{2: 2}},
+		{Type: "update", ItemKey: "c", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1, 2: 1}},
+		{Type: "update", ItemKey: "d", TargetItemKey: "e", VectorClock: VectorClock{3: 1}},
+		{Type: "update", ItemKey: "f", TargetItemKey: "e", VectorClock: VectorClock{4: 1}},
+	}
+	for _, order := range permutations([]int{0, 1, 2, 3, 4}) {
+		crdt := NewCRDT()
+		for _, i := range order {
+			crdt.Apply(events[i])
+		}
+		if err := crdt.Validate(); err != nil {
+			t.Fatalf("order %v: %v", order, err)
+		}
+		// d and f wait under the unknown target e.
+		assertOrder(t, crdt, []string{"f", "d", "b", "a", "c"})
+	}
+}
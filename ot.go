@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// OTInsert inserts Value at Index.
+	OTInsert = "insert"
+	// OTDelete deletes the item at Index.
+	OTDelete = "delete"
+	// OTNoop does nothing, it is what an operation becomes when a concurrent
+	// one already had the same effect, e.g. two deletes of the same item.
+	OTNoop = "noop"
+)
+
+// OTOp is an operation of a linear operational transform stream, made
+// against the document as it was at Revision.
+type OTOp struct {
+	Kind     string `json:"kind"`
+	Index    int    `json:"index"`
+	Value    string `json:"value,omitempty"`
+	Revision int    `json:"revision"`
+}
+
+// OTBridge lets a backend speaking linear OT edit a replica, and follow the
+// edits made by other replicas as OT operations. The document is seen as the
+// list of values in the order returned by Traverse.
+//
+// Every change to the replica must go through the bridge, so that the
+// revisions it hands out describe the replica's document.
+type OTBridge struct {
+	mu sync.Mutex
+	r  *Replica
+	// history holds the operation that produced each revision, so operations
+	// made against older revisions can be transformed.
+	history []OTOp
+	// keys is the document as the OT clients see it.
+	keys   []string
+	values []string
+}
+
+// NewOTBridge returns a bridge for the replica, starting at revision 0 with
+// the replica's current document.
+func NewOTBridge(r *Replica) *OTBridge {
+	b := &OTBridge{r: r}
+	b.keys, b.values = b.document()
+	return b
+}
+
+// Revision returns the current revision, i.e. the number of operations
+// applied through the bridge.
+func (b *OTBridge) Revision() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.history)
+}
+
+// Submit transforms an operation from a legacy client against every operation
+// applied since its revision, then applies it to the replica. It returns the
+// operations to send to the legacy clients, and the events to send to other
+// replicas. The first operation is the transformed one. The replica can
+// order the document differently from what that operation alone gives,
+// e.g. deleting an item hands its children to its parent, which sorts them
+// amongst its other children, so it is followed by the operations making up
+// the difference, which the client that made it needs as well.
+func (b *OTBridge) Submit(op OTOp) ([]OTOp, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if op.Revision < 0 || op.Revision > len(b.history) {
+		return nil, nil, fmt.Errorf("unknown revision %d, current revision is %d", op.Revision, len(b.history))
+	}
+	for _, prior := range b.history[op.Revision:] {
+		op = transformOT(op, prior)
+	}
+
+	// keys and values are the document the operation alone gives.
+	keys := append([]string{}, b.keys...)
+	values := append([]string{}, b.values...)
+	var events []Event
+	switch op.Kind {
+	case OTInsert:
+		if op.Index < 0 || op.Index > len(b.keys) {
+			return nil, nil, fmt.Errorf("insert at %d is out of range, document has %d items", op.Index, len(b.keys))
+		}
+		// new items go straight after their target, so the item before the
+		// index is the target.
+		target := rootKey
+		if op.Index > 0 {
+			target = b.keys[op.Index-1]
+		}
+		key := b.r.NewKey()
+		events = append(events, b.r.Insert(key, target), b.r.Set(key, op.Value))
+		keys = append(keys[:op.Index], append([]string{key}, keys[op.Index:]...)...)
+		values = append(values[:op.Index], append([]string{op.Value}, values[op.Index:]...)...)
+	case OTDelete:
+		if op.Index < 0 || op.Index >= len(b.keys) {
+			return nil, nil, fmt.Errorf("delete at %d is out of range, document has %d items", op.Index, len(b.keys))
+		}
+		events = append(events, b.r.Delete(b.keys[op.Index]))
+		keys = append(keys[:op.Index], keys[op.Index+1:]...)
+		values = append(values[:op.Index], values[op.Index+1:]...)
+	case OTNoop:
+	default:
+		return nil, nil, fmt.Errorf("unknown operation %q", op.Kind)
+	}
+
+	b.keys, b.values = keys, values
+	ops := b.record([]OTOp{op})
+	return append(ops, b.update()...), events, nil
+}
+
+// Apply applies an event from another replica, and returns the operations
+// that make the same change for the legacy clients. Moves and changed values
+// become a delete followed by an insert.
+func (b *OTBridge) Apply(e Event) []OTOp {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.r.Apply(e)
+	return b.update()
+}
+
+// update brings the document the legacy clients see up to date with the
+// replica's, and returns the operations that do so.
+func (b *OTBridge) update() []OTOp {
+	keys, values := b.document()
+
+	// a single event changes one part of the document, so everything before
+	// and after the changed part is left alone.
+	prefix := 0
+	for prefix < len(b.keys) && prefix < len(keys) && b.keys[prefix] == keys[prefix] && b.values[prefix] == values[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(b.keys)-prefix && suffix < len(keys)-prefix &&
+		b.keys[len(b.keys)-1-suffix] == keys[len(keys)-1-suffix] &&
+		b.values[len(b.values)-1-suffix] == values[len(values)-1-suffix] {
+		suffix++
+	}
+
+	var ops []OTOp
+	for i := prefix; i < len(b.keys)-suffix; i++ {
+		ops = append(ops, OTOp{Kind: OTDelete, Index: prefix})
+	}
+	for i := prefix; i < len(keys)-suffix; i++ {
+		ops = append(ops, OTOp{Kind: OTInsert, Index: i, Value: values[i]})
+	}
+
+	b.keys, b.values = keys, values
+	return b.record(ops)
+}
+
+// record adds the operations to the history, setting their revisions.
+func (b *OTBridge) record(ops []OTOp) []OTOp {
+	for i := range ops {
+		ops[i].Revision = len(b.history)
+		b.history = append(b.history, ops[i])
+	}
+	return ops
+}
+
+// document returns the keys and values of the replica's document in order.
+func (b *OTBridge) document() (keys, values []string) {
+	b.r.View(func(crdt *CRDT) {
+		for n := range crdt.Traverse() {
+			keys = append(keys, n.key)
+			values = append(values, n.value)
+		}
+	})
+	return keys, values
+}
+
+// transformOT returns 'op' changed to have the same intent once 'prior', an
+// operation made concurrently but applied first, has been applied. Inserts
+// at the same index keep the prior one first.
+func transformOT(op, prior OTOp) OTOp {
+	switch prior.Kind {
+	case OTInsert:
+		if prior.Index <= op.Index {
+			op.Index++
+		}
+	case OTDelete:
+		switch {
+		case prior.Index < op.Index:
+			op.Index--
+		case prior.Index == op.Index && op.Kind == OTDelete:
+			op.Kind = OTNoop
+		}
+	}
+	return op
+}
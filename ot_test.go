@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// otClient is a legacy client's copy of the document, kept up to date with
+// the operations from the bridge.
+type otClient []string
+
+func (c *otClient) apply(ops []OTOp) {
+	for _, op := range ops {
+		switch op.Kind {
+		case OTInsert:
+			*c = append((*c)[:op.Index], append([]string{op.Value}, (*c)[op.Index:]...)...)
+		case OTDelete:
+			*c = append((*c)[:op.Index], (*c)[op.Index+1:]...)
+		}
+	}
+}
+
+func TestOTBridgeDeleteReordersChildren(t *testing.T) {
+	b := NewOTBridge(New(WithID(1)))
+	var client otClient
+	submit := func(op OTOp) {
+		t.Helper()
+		op.Revision = b.Revision()
+		ops, _, err := b.Submit(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.apply(ops)
+	}
+
+	submit(OTOp{Kind: OTInsert, Index: 0, Value: "x"})
+	submit(OTOp{Kind: OTInsert, Index: 1, Value: "y"})
+	submit(OTOp{Kind: OTInsert, Index: 0, Value: "w"})
+	submit(OTOp{Kind: OTInsert, Index: 2, Value: "z"})
+	if got := fmt.Sprint(client); got != "[w x z y]" {
+		t.Fatalf("client has %s, want [w x z y]", got)
+	}
+
+	// z is a child of x, deleting x hands it to the root, where it is the
+	// newest child and so comes first.
+	submit(OTOp{Kind: OTDelete, Index: 1})
+	_, values := b.document()
+	if got := fmt.Sprint(values); got != "[z w y]" {
+		t.Fatalf("replica has %s, want [z w y]", got)
+	}
+	if got := fmt.Sprint(client); got != "[z w y]" {
+		t.Fatalf("client has %s, want [z w y]", got)
+	}
+}
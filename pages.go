@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// nextPageHeader carries the token of the next page of a paged list.
+	nextPageHeader = "CRDT-Next-Page"
+	// apiPageSnapshots is the number of snapshots kept for paging. Pages
+	// of older ones fail with 410 Gone.
+	apiPageSnapshots = 8
+)
+
+// errSnapshotExpired is the error for page tokens of snapshots that are no
+// longer kept.
+var errSnapshotExpired = errors.New("snapshot expired, list again from the first page")
+
+// apiPage is what a page token encodes: the snapshot being paged through,
+// as the length of the feed when it was taken, and where the page starts.
+type apiPage struct {
+	Snapshot int `json:"s"`
+	Index    int `json:"i"`
+}
+
+// apiPages holds the snapshots of the document being paged through, so that
+// every page of a list comes from the same version of the document however
+// it is edited in between.
+type apiPages struct {
+	mu        sync.Mutex
+	snapshots map[int][]apiNode
+	// order holds the snapshots' keys, oldest first.
+	order []int
+}
+
+// list writes the page of nodes starting at the 'page' token, or the
+// first page of a new snapshot without one, with up to 'limit' nodes. The
+// token of the next page, if there is one, is set in the CRDT-Next-Page
+// header.
+func (p *apiPages) list(w http.ResponseWriter, r *Replica, token, limit string) {
+	size, err := strconv.Atoi(limit)
+	if err != nil || size <= 0 {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	var page apiPage
+	var nodes []apiNode
+	if token == "" {
+		page.Snapshot, nodes = p.take(r)
+	} else {
+		if page, err = decodePageToken(token); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if nodes, err = p.get(r, page.Snapshot); errors.Is(err, errSnapshotExpired) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if page.Index < 0 || page.Index > len(nodes) {
+		http.Error(w, "invalid page token", http.StatusBadRequest)
+		return
+	}
+
+	end := page.Index + size
+	if end < len(nodes) {
+		w.Header().Set(nextPageHeader, encodePageToken(apiPage{Snapshot: page.Snapshot, Index: end}))
+	} else {
+		end = len(nodes)
+	}
+	apiWrite(w, http.StatusOK, nodes[page.Index:end])
+}
+
+// take snapshots the replica's document, returning the snapshot's key.
+func (p *apiPages) take(r *Replica) (int, []apiNode) {
+	var snapshot int
+	nodes := []apiNode{}
+	r.View(func(crdt *CRDT) {
+		// events are appended to the feed with the replica locked, so its
+		// length matches the document.
+		snapshot = r.Feed().Len()
+		for n := range crdt.Traverse() {
+			nodes = append(nodes, newAPINode(n))
+		}
+	})
+	p.put(snapshot, nodes)
+	return snapshot, nodes
+}
+
+// get returns the snapshot, or errSnapshotExpired if it is no longer kept.
+// Snapshots aren't rebuilt from the feed, as replaying it for every old
+// token would let any client make the server do that work.
+func (p *apiPages) get(r *Replica, snapshot int) ([]apiNode, error) {
+	if snapshot < 0 || snapshot > r.Feed().Len() {
+		return nil, errors.New("invalid page token")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nodes, exists := p.snapshots[snapshot]
+	if !exists {
+		return nil, errSnapshotExpired
+	}
+	return nodes, nil
+}
+
+// put keeps the snapshot, dropping the oldest one if there are too many.
+func (p *apiPages) put(snapshot int, nodes []apiNode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.snapshots == nil {
+		p.snapshots = map[int][]apiNode{}
+	}
+	if _, exists := p.snapshots[snapshot]; exists {
+		return
+	}
+	p.snapshots[snapshot] = nodes
+	p.order = append(p.order, snapshot)
+	if len(p.order) > apiPageSnapshots {
+		delete(p.snapshots, p.order[0])
+		p.order = p.order[1:]
+	}
+}
+
+func encodePageToken(page apiPage) string {
+	b, _ := json.Marshal(page)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageToken(token string) (apiPage, error) {
+	var page apiPage
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return page, fmt.Errorf("invalid page token: %v", err)
+	}
+	if err := json.Unmarshal(b, &page); err != nil {
+		return page, fmt.Errorf("invalid page token: %v", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageTokens(t *testing.T) {
+	r := New(WithID(1))
+	r.Insert("a", rootKey)
+	r.Insert("b", rootKey)
+	api := NewAPI(r)
+
+	get := func(page string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest("GET", "/nodes?limit=1&page="+page, nil))
+		return rec
+	}
+
+	first := get("")
+	next := first.Header().Get(nextPageHeader)
+	if first.Code != http.StatusOK || next == "" {
+		t.Fatalf("first page gave %d with next page %q", first.Code, next)
+	}
+	if rec := get(next); rec.Code != http.StatusOK {
+		t.Fatalf("second page gave %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, snapshot := range []int{-1, r.Feed().Len() + 1} {
+		if rec := get(encodePageToken(apiPage{Snapshot: snapshot})); rec.Code != http.StatusBadRequest {
+			t.Errorf("snapshot %d gave %d, want %d", snapshot, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	// newer snapshots push the first one out.
+	for i := 0; i < apiPageSnapshots; i++ {
+		r.Set("a", string(rune('0'+i)))
+		get("")
+	}
+	if rec := get(next); rec.Code != http.StatusGone {
+		t.Errorf("page of an expired snapshot gave %d, want %d", rec.Code, http.StatusGone)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import "sync"
+
+// TraverseParallel calls 'fn' with every node returned by Traverse, using up
+// to 'workers' goroutines. The nodes are split into disjoint subtrees, each
+// rooted at a child of the root (or at a node under a ghost node that
+// Traverse returns), and each subtree is visited by a single goroutine in
+// the same order as Traverse. There is no ordering between subtrees, so
+// 'fn' is called concurrently and must be safe for that.
+// The CRDT must not be changed until TraverseParallel returns.
+func (crdt *CRDT) TraverseParallel(workers int, fn func(*node)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	subtrees := make(chan *node)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for root := range subtrees {
+				for n := root; n != nil; n = n.nextWithin(root) {
+					fn(n)
+				}
+			}
+		}()
+	}
+
+	for c := crdt.nodes[rootKey].children.first(); c != nil; c = c.nextSibling() {
+		if c.key != ghostKey {
+			subtrees <- c
+		}
+	}
+	for g := crdt.nodes[ghostKey].children.first(); g != nil; g = g.nextSibling() {
+		for c := g.children.first(); c != nil; c = c.nextSibling() {
+			subtrees <- c
+		}
+	}
+	close(subtrees)
+
+	wg.Wait()
+}
+
+// nextWithin returns the node after 'n' in a depth first search of the
+// subtree rooted at 'root', or nil if there isn't one.
+func (n *node) nextWithin(root *node) *node {
+	if first := n.children.first(); first != nil {
+		return first
+	}
+	for ; n != root; n = n.parent {
+		if sibling := n.nextSibling(); sibling != nil {
+			return sibling
+		}
+	}
+	return nil
+}
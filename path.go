@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParsePath compiles a path expression in the style of XPath, for queries
+// carried over from XML tooling. Where XPath matches element names, a path
+// matches node values:
+//
+//	/name           the children of the root with the value
+//	//name          the descendants of the root with the value
+//	"name"          a value containing '/', '[' or ']', quoted
+//	*               any node
+//	[n]             the nth of the nodes matched under each parent, from 1
+//	[last()]        the last of the nodes matched under each parent
+//	[@field=value]  nodes whose value is a JSON object with the field set to
+//	                the value, or with [@field] just having the field
+//
+// So /projects/*/tasks[last()] matches the last "tasks" node of every
+// project. A position has to be the last predicate of its step.
+func ParsePath(path string) (*Selector, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %v", path, err)
+	}
+	return &Selector{query: path, steps: steps}, nil
+}
+
+func parsePath(path string) ([]queryStep, error) {
+	var steps []queryStep
+	rest := path
+	if !strings.HasPrefix(rest, "/") {
+		// a relative path is relative to the root.
+		rest = "/" + rest
+	}
+	for rest != "" {
+		if !strings.HasPrefix(rest, "/") {
+			return nil, fmt.Errorf("expected '/' at %d", len(path)-len(rest))
+		}
+		rest = rest[1:]
+		if strings.HasPrefix(rest, "/") {
+			// as in XPath, '//' is short for a step to every descendant,
+			// so a position applies amongst the children of each of them.
+			rest = rest[1:]
+			steps = append(steps, queryStep{axis: axisDescendantOrSelf, test: func(*node) bool { return true }})
+		}
+
+		step, n, err := pathStep(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%v at %d", err, len(path)-len(rest)+n)
+		}
+		steps = append(steps, step)
+		rest = rest[n:]
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return steps, nil
+}
+
+// pathStep parses the step at the start of 's', returning it and its
+// length, or the error and where in 's' it is.
+func pathStep(s string) (queryStep, int, error) {
+	step := queryStep{axis: axisChild}
+	var tests []func(*node) bool
+
+	i := 0
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return step, 0, fmt.Errorf("unterminated string")
+		}
+		name, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return step, 0, fmt.Errorf("invalid string: %v", err)
+		}
+		i = end + 1
+		tests = append(tests, func(n *node) bool { return n.value == name })
+	case strings.HasPrefix(s, "*"):
+		i = 1
+	default:
+		i = strings.IndexAny(s, "/[")
+		if i < 0 {
+			i = len(s)
+		}
+		name := s[:i]
+		if name == "" {
+			return step, 0, fmt.Errorf("expected a name")
+		}
+		tests = append(tests, func(n *node) bool { return n.value == name })
+	}
+
+	for i < len(s) && s[i] == '[' {
+		end := strings.IndexByte(s[i:], ']')
+		if end < 0 {
+			return step, i, fmt.Errorf("expected ']'")
+		}
+		predicate := s[i+1 : i+end]
+		if step.position != nil {
+			return step, i, fmt.Errorf("a position has to be the last predicate")
+		}
+
+		switch {
+		case predicate == "last()":
+			step.position = func(count int) int { return count - 1 }
+		case strings.HasPrefix(predicate, "@"):
+			field, value, hasValue := strings.Cut(predicate[1:], "=")
+			if field == "" {
+				return step, i + 1, fmt.Errorf("expected a field")
+			}
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			tests = append(tests, fieldTest(field, value, hasValue))
+		default:
+			position, err := strconv.Atoi(predicate)
+			if err != nil || position < 1 {
+				return step, i + 1, fmt.Errorf("unknown predicate %q", predicate)
+			}
+			step.position = func(int) int { return position - 1 }
+		}
+		i += end + 1
+	}
+
+	step.test = func(n *node) bool {
+		for _, test := range tests {
+			if !test(n) {
+				return false
+			}
+		}
+		return true
+	}
+	return step, i, nil
+}
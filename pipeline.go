@@ -0,0 +1,154 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// pipelineEvent is an event submitted to a Pipeline for a document.
+type pipelineEvent struct {
+	doc   string
+	event Event
+}
+
+// Pipeline applies events to many documents using a fixed number of worker
+// goroutines. Documents are sharded across the workers, so the events of
+// one document are always applied by the same worker, in the order they
+// were submitted, while different documents are applied in parallel.
+type Pipeline struct {
+	shards    []chan pipelineEvent
+	batchSize int
+	onBatch   func(doc string, events []Event)
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	docs    map[string]*Replica
+	metrics *DocumentMetrics
+}
+
+// NewPipeline starts a Pipeline with 'shards' workers. Each worker takes up
+// to 'batchSize' queued events at a time, and after applying them calls
+// 'onBatch', if it isn't nil, once per document with that document's events
+// in order. This is where writes to storage can be batched.
+func NewPipeline(shards, batchSize int, onBatch func(doc string, events []Event)) *Pipeline {
+	if shards < 1 {
+		shards = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	p := &Pipeline{
+		shards:    make([]chan pipelineEvent, shards),
+		batchSize: batchSize,
+		onBatch:   onBatch,
+		docs:      map[string]*Replica{},
+	}
+	for i := range p.shards {
+		p.shards[i] = make(chan pipelineEvent, batchSize)
+		p.wg.Add(1)
+		go p.work(p.shards[i])
+	}
+	return p
+}
+
+// Submit queues the event to be applied to the document, blocking while the
+// document's shard is full.
+func (p *Pipeline) Submit(doc string, e Event) {
+	h := fnv.New32a()
+	h.Write([]byte(doc))
+	p.shards[h.Sum32()%uint32(len(p.shards))] <- pipelineEvent{doc: doc, event: e}
+}
+
+// Close waits for every submitted event to be applied, then stops the
+// workers and closes the documents' replicas. Submit must not be called
+// after Close.
+func (p *Pipeline) Close() {
+	for _, shard := range p.shards {
+		close(shard)
+	}
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.docs {
+		r.Close()
+	}
+}
+
+// Replica returns the replica holding the document, creating it if it
+// doesn't exist. Replicas created by the pipeline only apply events, they
+// don't generate any, so they use client id 0.
+func (p *Pipeline) Replica(doc string) *Replica {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, exists := p.docs[doc]
+	if !exists {
+		r = NewReplica(0)
+		if p.metrics != nil {
+			r.SetMetrics(p.metrics.For(doc))
+		}
+		p.docs[doc] = r
+	}
+	return r
+}
+
+// SetMetrics sets the metrics of the documents' replicas, including those
+// already created.
+func (p *Pipeline) SetMetrics(d *DocumentMetrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics = d
+	for doc, r := range p.docs {
+		r.SetMetrics(d.For(doc))
+	}
+}
+
+// Docs returns the documents that have a replica, in no particular order.
+func (p *Pipeline) Docs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	docs := make([]string, 0, len(p.docs))
+	for doc := range p.docs {
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func (p *Pipeline) work(shard chan pipelineEvent) {
+	defer p.wg.Done()
+
+	batch := make([]pipelineEvent, 0, p.batchSize)
+	for pe := range shard {
+		// take whatever else is already queued, up to the batch size.
+		batch = append(batch[:0], pe)
+	fill:
+		for len(batch) < p.batchSize {
+			select {
+			case pe, ok := <-shard:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, pe)
+			default:
+				break fill
+			}
+		}
+
+		order := []string{}
+		byDoc := map[string][]Event{}
+		for _, pe := range batch {
+			p.Replica(pe.doc).Apply(pe.event)
+			if _, seen := byDoc[pe.doc]; !seen {
+				order = append(order, pe.doc)
+			}
+			byDoc[pe.doc] = append(byDoc[pe.doc], pe.event)
+		}
+
+		if p.onBatch != nil {
+			for _, doc := range order {
+				p.onBatch(doc, byDoc[doc])
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestPipelineAppliesEachDocumentInOrder(t *testing.T) {
+	var mu sync.Mutex
+	batched := map[string][]Event{}
+	p := NewPipeline(4, 16, func(doc string, events []Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		batched[doc] = append(batched[doc], events...)
+	})
+
+	for i := 0; i < 100; i++ {
+		for _, doc := range []string{"a", "b", "c"} {
+			p.Submit(doc, Event{Type: "update", ItemKey: fmt.Sprint(i), TargetItemKey: rootKey, VectorClock: VectorClock{1: i + 1}})
+		}
+	}
+	p.Close()
+
+	for _, doc := range []string{"a", "b", "c"} {
+		if len(batched[doc]) != 100 {
+			t.Fatalf("%s: %d events batched, want 100", doc, len(batched[doc]))
+		}
+		for i, e := range batched[doc] {
+			if e.ItemKey != fmt.Sprint(i) {
+				t.Fatalf("%s: event %d is for %s, want %d", doc, i, e.ItemKey, i)
+			}
+		}
+		p.Replica(doc).View(func(crdt *CRDT) {
+			if got := len(crdt.nodes) - 2; got != 100 {
+				t.Errorf("%s has %d nodes, want 100", doc, got)
+			}
+		})
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	const docs = 1000
+	for _, shards := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			p := NewPipeline(shards, 64, nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i/docs + 1
+				p.Submit(fmt.Sprint(i%docs), Event{Type: "update", ItemKey: fmt.Sprint(n), TargetItemKey: rootKey, VectorClock: VectorClock{1: n}})
+			}
+			p.Close()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xlab/treeprint"
+)
+
+// Printer prints the internal tree of a CRDT in a canonical form, so that
+// equal states always print the same text whatever the platform or Go
+// version, which makes it suitable for golden files. Clocks are printed
+// with their entries sorted by client id, instead of relying on how fmt
+// formats maps.
+type Printer struct {
+	// HideGhost leaves out the ghost node, and so the deleted nodes and
+	// unknown targets under it.
+	HideGhost bool
+	// HideClocks leaves out the clock of every node.
+	HideClocks bool
+	// ShowValues prints the value of every node that has one.
+	ShowValues bool
+	// Highlight is the keys of nodes to print in color, e.g. the nodes
+	// changed by the most recent events.
+	Highlight []string
+	// MaxDepth is the depth of the deepest nodes printed, the children of
+	// the root are at depth 1. Nodes at that depth show how many nodes are
+	// hidden under them. Zero means no limit.
+	MaxDepth int
+}
+
+// Print returns the tree of the CRDT, in the same layout as String.
+func (p Printer) Print(crdt *CRDT) string {
+	highlight := map[string]bool{}
+	for _, key := range p.Highlight {
+		highlight[key] = true
+	}
+
+	var addNode func(t treeprint.Tree, n *node, depth int)
+	addNode = func(t treeprint.Tree, n *node, depth int) {
+		treeNode := t.AddBranch(p.label(n, highlight[n.key]))
+
+		if p.MaxDepth > 0 && depth == p.MaxDepth {
+			if hidden := p.count(n) - 1; hidden > 0 {
+				treeNode.AddNode("... " + strconv.Itoa(hidden) + " more")
+			}
+			return
+		}
+
+		for _, c := range n.children.slice() {
+			if p.HideGhost && c.key == ghostKey {
+				continue
+			}
+			addNode(treeNode, c, depth+1)
+		}
+	}
+
+	tree := treeprint.New()
+	addNode(tree, crdt.nodes[rootKey], 0)
+
+	return tree.String()
+}
+
+func (p Printer) label(n *node, highlight bool) string {
+	label := n.key
+	if p.ShowValues && n.value != "" {
+		label += " = " + strconv.Quote(n.value)
+	}
+	if !p.HideClocks {
+		label += " (" + canonicalClock(n.latestVectorClock) + ")"
+	}
+	if highlight {
+		label = ansiHighlight + label + ansiReset
+	}
+	return label
+}
+
+// count returns the number of nodes in the subtree of 'n', including 'n',
+// leaving out the ghost branch if it is hidden.
+func (p Printer) count(n *node) int {
+	total := 1
+	for _, c := range n.children.slice() {
+		if p.HideGhost && c.key == ghostKey {
+			continue
+		}
+		total += p.count(c)
+	}
+	return total
+}
+
+// canonicalClock formats the clock as space separated id:time pairs,
+// sorted by id.
+func canonicalClock(v VectorClock) string {
+	ids := make([]int, 0, len(v))
+	for id := range v {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	pairs := make([]string, len(ids))
+	for i, id := range ids {
+		pairs[i] = strconv.Itoa(id) + ":" + strconv.Itoa(v[id])
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestPrinterGolden(t *testing.T) {
+	r := NewReplica(1)
+	r.Insert("a", rootKey)
+	r.Insert("b", "a")
+	r.Insert("c", rootKey)
+	r.Set("b", "hello")
+	r.Delete("a")
+	r.Insert("d", "unknown")
+
+	r.View(func(crdt *CRDT) {
+		assertOrder(t, crdt, []string{"d", "c", "b"})
+		assertGolden(t, "tree", Printer{ShowValues: true}, crdt)
+		assertGolden(t, "tree_hide_ghost", Printer{HideGhost: true, HideClocks: true}, crdt)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// PurgeTombstones removes the deleted nodes whose deletion is stable, see
+// StableClock, from the CRDT entirely, and returns how many it removed. No
+// event concurrent with a stable delete can still arrive, so the tombstone
+// is no longer needed to order one. Tombstones with children or a value are
+// kept, since a later update, e.g. an undo, brings the node back with them.
+// A replica without peers, see AddPeer, purges nothing, as it can't know
+// which events every other replica has.
+func (r *Replica) PurgeTombstones() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crdt.purge(r.stable)
+}
+
+// PurgeTask returns a maintenance task purging stable tombstones, see
+// PurgeTombstones.
+func PurgeTask(interval, jitter time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "purge",
+		Interval: interval,
+		Jitter:   jitter,
+		Run: func(ctx context.Context, doc string, r *Replica) error {
+			r.PurgeTombstones()
+			return nil
+		},
+	}
+}
+
+// purge removes the tombstones covered by the stable clock from the ghost
+// node and the index of nodes, returning how many it removed.
+func (crdt *CRDT) purge(stable VectorClock) int {
+	purged := 0
+	ghost := crdt.nodes[ghostKey]
+	for _, n := range ghost.children.slice() {
+		// unknown targets have no clock, and are left to ReapGhosts.
+		if len(n.latestVectorClock) == 0 || n.children.len() > 0 || n.value != "" {
+			continue
+		}
+		if !stable.Covers(n.latestVectorClock) || !stable.Covers(n.valueVectorClock) {
+			continue
+		}
+		ghost.children.remove(n)
+		delete(crdt.nodes, n.key)
+		purged++
+	}
+	if purged > 0 {
+		crdt.forgetMoves()
+	}
+	crdt.purged += purged
+	return purged
+}
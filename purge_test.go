@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+// purgeEvents delete t, which x was moved under, followed by events that
+// arrive late: y is concurrent with every one of them.
+var purgeEvents = []Event{
+	{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+	{Type: "update", ItemKey: "t", TargetItemKey: rootKey, VectorClock: VectorClock{1: 2}},
+	{Type: "update", ItemKey: "x", TargetItemKey: "t", VectorClock: VectorClock{1: 3}},
+	{Type: "delete", ItemKey: "t", VectorClock: VectorClock{1: 4}},
+}
+
+var lateEvents = []Event{
+	{Type: "update", ItemKey: "y", TargetItemKey: "a", VectorClock: VectorClock{1: 1, 2: 1}},
+	{Type: "update", ItemKey: "z", TargetItemKey: rootKey, VectorClock: VectorClock{1: 5, 2: 1}},
+}
+
+func TestPurgeTombstonesWaitsForPeers(t *testing.T) {
+	r1, r2 := New(WithID(1)), New(WithID(3))
+	for _, e := range purgeEvents {
+		r1.Apply(e)
+		r2.Apply(e)
+	}
+
+	if n := r1.PurgeTombstones(); n != 0 {
+		t.Fatalf("purged %d tombstones without peers, want 0", n)
+	}
+	r1.AddPeer(2)
+	if n := r1.PurgeTombstones(); n != 0 {
+		t.Fatalf("purged %d tombstones before the peer acknowledged them, want 0", n)
+	}
+
+	for _, e := range lateEvents {
+		r1.Apply(e)
+		r2.Apply(e)
+	}
+	assertConverged(t, r1, r2)
+
+	r1.Acknowledge(2, r1.Clock())
+	if n := r1.PurgeTombstones(); n != 1 {
+		t.Fatalf("purged %d tombstones once they are stable, want 1", n)
+	}
+}
+
+func TestPurgeForgetsMovesUnderPurgedNodes(t *testing.T) {
+	purged, kept := NewCRDT(), NewCRDT()
+	for _, e := range purgeEvents {
+		purged.Apply(e)
+		kept.Apply(e)
+	}
+	if n := purged.purge(VectorClock{1: 4}); n != 1 {
+		t.Fatalf("purged %d tombstones, want 1", n)
+	}
+
+	// undoing and redoing x's move under t mustn't bring t back.
+	for _, e := range lateEvents {
+		purged.Apply(e)
+		kept.Apply(e)
+	}
+	if _, exists := purged.nodes["t"]; exists {
+		t.Errorf("t is back after being purged")
+	}
+	assertOrder(t, purged, []string{"z", "x", "a", "y"})
+	assertOrder(t, kept, []string{"z", "x", "a", "y"})
+}
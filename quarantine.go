@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// quarantineSize is the most rejected events kept in a replica's
+// quarantine, the oldest are dropped first.
+const quarantineSize = 1000
+
+// QuarantinedEvent is an event a replica rejected, see Replica.Quarantine.
+type QuarantinedEvent struct {
+	Event    Event     `json:"event"`
+	Reason   string    `json:"reason"`
+	Rejected time.Time `json:"rejected"`
+}
+
+// Quarantine returns the events the replica rejected, because of its
+// ClockGuard, Quota or value validator, with the reasons, oldest first.
+func (r *Replica) Quarantine() []QuarantinedEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]QuarantinedEvent{}, r.quarantine...)
+}
+
+// ReplayQuarantine empties the quarantine and applies its events again,
+// e.g. after the policy that rejected them was fixed. It returns how many
+// were applied, the rest are back in the quarantine with their new reasons.
+func (r *Replica) ReplayQuarantine() int {
+	r.mu.Lock()
+	quarantined := r.quarantine
+	r.quarantine = nil
+	r.mu.Unlock()
+
+	applied := 0
+	for _, q := range quarantined {
+		if r.TryApply(q.Event) == nil {
+			applied++
+		}
+	}
+	return applied
+}
+
+// quarantineEvent adds a rejected event to the quarantine. r.mu must be
+// held.
+func (r *Replica) quarantineEvent(e Event, err error) {
+	if len(r.quarantine) >= quarantineSize {
+		r.quarantine = r.quarantine[1:]
+	}
+	r.quarantine = append(r.quarantine, QuarantinedEvent{Event: e, Reason: err.Error(), Rejected: time.Now()})
+}
+
+// NewQuarantineHandler returns an http.Handler listing the replica's
+// quarantined events as JSON on GET, and replaying them on POST.
+func NewQuarantineHandler(r *Replica) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			apiWrite(w, http.StatusOK, r.Quarantine())
+		case http.MethodPost:
+			applied := r.ReplayQuarantine()
+			apiWrite(w, http.StatusOK, struct {
+				Applied   int `json:"applied"`
+				Remaining int `json:"remaining"`
+			}{applied, len(r.Quarantine())})
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
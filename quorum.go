@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// replicatedHeader marks events sent by a server to its peers, which apply
+// them without sending them on. Its value is the peers' shared secret.
+const replicatedHeader = "CRDT-Replicated"
+
+// Quorum sends the events submitted to a server on to its peers, and waits
+// for enough of them to apply them before the server acknowledges them, so
+// acknowledged events survive the loss of the server. The server applies
+// the events before sending them on, so they can be seen, and are kept,
+// even when the quorum isn't reached; the client retrying is harmless.
+type Quorum struct {
+	// Peers are the base URLs of the peer servers.
+	Peers []string
+	// Size is the number of peers that must apply the events.
+	Size int
+	// Timeout is how long to wait for the peers.
+	Timeout time.Duration
+	// Client makes the requests, http.DefaultClient if nil.
+	Client *http.Client
+	// Secret is shared by the peers, so they only trust each other to have
+	// replicated events already. Requests without it are replicated as if
+	// they came from a client.
+	Secret string
+}
+
+// fromPeer checks whether the request was sent by a peer replicating events.
+func (q *Quorum) fromPeer(req *http.Request) bool {
+	header := req.Header.Get(replicatedHeader)
+	return q.Secret != "" && subtle.ConstantTimeCompare([]byte(header), []byte(q.Secret)) == 1
+}
+
+// Replicate posts the newline delimited JSON events to every peer at once,
+// returning once Size of them have applied them, or an error once that
+// can't happen anymore. Peers that haven't answered by then carry on in the
+// background.
+func (q *Quorum) Replicate(ctx context.Context, events []byte) error {
+	if q.Size <= 0 {
+		return nil
+	}
+	if q.Size > len(q.Peers) {
+		return fmt.Errorf("quorum of %d needs more than the %d peers", q.Size, len(q.Peers))
+	}
+	client := q.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), q.Timeout)
+
+	results := make(chan error, len(q.Peers))
+	for _, peer := range q.Peers {
+		go func(peer string) {
+			results <- q.post(ctx, client, peer, events)
+		}(peer)
+	}
+	// the peers still posting need the context, so only cancel it once
+	// they are all done.
+	done := func(remaining int) {
+		go func() {
+			for ; remaining > 0; remaining-- {
+				<-results
+			}
+			cancel()
+		}()
+	}
+
+	acked, failed := 0, 0
+	var lastErr error
+	for i := range q.Peers {
+		if err := <-results; err != nil {
+			failed++
+			lastErr = err
+		} else {
+			acked++
+		}
+		switch {
+		case acked >= q.Size:
+			done(len(q.Peers) - i - 1)
+			return nil
+		case len(q.Peers)-failed < q.Size:
+			done(len(q.Peers) - i - 1)
+			return fmt.Errorf("quorum of %d can't be reached, %d of %d peers failed: %w", q.Size, failed, len(q.Peers), lastErr)
+		}
+	}
+	cancel()
+	return nil
+}
+
+func (q *Quorum) post(ctx context.Context, client *http.Client, peer string, events []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+"/events", bytes.NewReader(events))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(versionHeader, strconv.Itoa(wireVersion))
+	req.Header.Set(replicatedHeader, q.Secret)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", peer, resp.Status)
+	}
+	return nil
+}
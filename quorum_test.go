@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnlyPeersSkipReplication(t *testing.T) {
+	var posts atomic.Int32
+	peer := New(WithID(2))
+	peerEvents := NewEventsHandler(peer, nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		posts.Add(1)
+		peerEvents.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+
+	quorum := &Quorum{Peers: []string{ts.URL}, Size: 1, Timeout: time.Second, Secret: "s3cret"}
+	h := NewEventsHandler(New(WithID(1)), quorum)
+
+	for i, tc := range []struct {
+		header    string
+		replicate bool
+	}{
+		{header: "", replicate: true},
+		{header: "1", replicate: true},
+		{header: "s3cret", replicate: false},
+	} {
+		posts.Store(0)
+		body, err := json.Marshal(Event{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{3: i + 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+		if tc.header != "" {
+			req.Header.Set(replicatedHeader, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("header %q: status %d: %s", tc.header, rec.Code, rec.Body)
+		}
+		if replicated := posts.Load() > 0; replicated != tc.replicate {
+			t.Errorf("header %q: replicated is %v, want %v", tc.header, replicated, tc.replicate)
+		}
+	}
+}
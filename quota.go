@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is wrapped by the errors of events that would take a
+// document over its Quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the size of a document, protecting a server from documents
+// growing without bound. Zero means no limit.
+//
+// The limit on values only depends on the event, so every replica with the
+// same Quota rejects the same events, and TryApply checks it on received
+// events too. The other limits depend on the document the event is applied
+// to, so they are only checked by Check, on local operations before they
+// become events, e.g. those made through the API. A received event has
+// already been applied by the replica that made it, and rejecting it
+// because of what this replica's document holds would leave them diverged.
+type Quota struct {
+	// MaxNodes is the most nodes the document can hold, including deleted
+	// nodes and unknown targets.
+	MaxNodes int
+	// MaxDepth is the deepest a node can be, the children of the root are
+	// at depth 1.
+	MaxDepth int
+	// MaxChildren is the most children a node can have.
+	MaxChildren int
+	// MaxValueSize is the longest value, in bytes.
+	MaxValueSize int
+}
+
+// SetQuota sets the Quota checked by Check, and by TryApply for values.
+func (r *Replica) SetQuota(q Quota) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quota = q
+}
+
+// check returns why the event goes over the limits that only depend on the
+// event.
+func (q Quota) check(e Event) error {
+	if e.Type == "set" && q.MaxValueSize > 0 && len(e.Value) > q.MaxValueSize {
+		return fmt.Errorf("%w: value of %s is %d bytes, the limit is %d", ErrQuotaExceeded, e.ItemKey, len(e.Value), q.MaxValueSize)
+	}
+	return nil
+}
+
+// checkDocument returns why the event would take the document over the
+// limits on its shape.
+func (q Quota) checkDocument(crdt *CRDT, e Event) error {
+	switch e.Type {
+	case "set":
+		if _, exists := crdt.nodes[e.ItemKey]; !exists && q.MaxNodes > 0 && len(crdt.nodes)-2 >= q.MaxNodes {
+			return fmt.Errorf("%w: document is at its limit of %d nodes", ErrQuotaExceeded, q.MaxNodes)
+		}
+	case "update":
+		item, itemExists := crdt.nodes[e.ItemKey]
+		target, targetExists := crdt.nodes[e.TargetItemKey]
+
+		// the root and ghost nodes don't count.
+		added := 0
+		if !itemExists {
+			added++
+		}
+		if !targetExists {
+			added++
+		}
+		if q.MaxNodes > 0 && added > 0 && len(crdt.nodes)-2+added > q.MaxNodes {
+			return fmt.Errorf("%w: document is at its limit of %d nodes", ErrQuotaExceeded, q.MaxNodes)
+		}
+		if !targetExists {
+			// an unknown target has no depth or children yet.
+			return nil
+		}
+
+		if q.MaxChildren > 0 && (!itemExists || item.parent != target) {
+			children := target.children.len()
+			if target.key == rootKey {
+				children--
+			}
+			if children >= q.MaxChildren {
+				return fmt.Errorf("%w: %s is at its limit of %d children", ErrQuotaExceeded, e.TargetItemKey, q.MaxChildren)
+			}
+		}
+		if q.MaxDepth > 0 {
+			depth := nodeDepth(target) + 1
+			if itemExists {
+				depth += subtreeHeight(item)
+			}
+			if depth > q.MaxDepth {
+				return fmt.Errorf("%w: %s would reach depth %d, the limit is %d", ErrQuotaExceeded, e.ItemKey, depth, q.MaxDepth)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeDepth returns the number of ancestors of the node below the root.
+func nodeDepth(n *node) int {
+	depth := 0
+	for ; n.parent != nil; n = n.parent {
+		depth++
+	}
+	return depth
+}
+
+// subtreeHeight returns how many levels of descendants the node has.
+func subtreeHeight(n *node) int {
+	height := 0
+	for _, c := range n.children.slice() {
+		if h := 1 + subtreeHeight(c); h > height {
+			height = h
+		}
+	}
+	return height
+}
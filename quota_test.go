@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuotaOnlyLimitsLocalOperations(t *testing.T) {
+	quota := Quota{MaxNodes: 2, MaxChildren: 2}
+	r1 := New(WithID(1), WithQuota(quota))
+	r2 := New(WithID(2), WithQuota(quota))
+
+	// each replica is within its quota, but not once it has the other's
+	// nodes too.
+	e1 := r1.Insert("a", rootKey)
+	e2 := r2.Insert("b", rootKey)
+	e3 := r2.Insert("c", rootKey)
+	for _, e := range []Event{e2, e3} {
+		if err := r1.TryApply(e); err != nil {
+			t.Fatalf("replica 1 rejected %s from replica 2: %v", e.ItemKey, err)
+		}
+	}
+	if err := r2.TryApply(e1); err != nil {
+		t.Fatalf("replica 2 rejected a from replica 1: %v", err)
+	}
+
+	assertConverged(t, r1, r2)
+
+	// local operations are still limited.
+	if err := r1.Check(Event{Type: "update", ItemKey: "d", TargetItemKey: rootKey}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Check gave %v, want %v", err, ErrQuotaExceeded)
+	}
+}
+
+func TestQuotaLimitsReceivedValues(t *testing.T) {
+	r := New(WithID(1), WithQuota(Quota{MaxValueSize: 3}))
+	e := Event{Type: "set", ItemKey: "a", Value: "long", VectorClock: VectorClock{2: 1}}
+	if err := r.TryApply(e); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("TryApply gave %v, want %v", err, ErrQuotaExceeded)
+	}
+}
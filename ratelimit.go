@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of client buckets kept before the ones that
+// have refilled completely are dropped.
+const maxIdleBuckets = 1024
+
+// tokenBucket allows 'rate' requests per second, with bursts of up to 'burst'.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket and takes a token from it if there is one. If not,
+// it returns how long until there will be.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// RateLimit limits the rate at which changes are made through a handler.
+// Requests that don't change anything (GET and HEAD) are never limited.
+type RateLimit struct {
+	// ClientRate and ClientBurst limit the changes per second made by each
+	// client, as identified by clientID. Zero means no limit.
+	ClientRate  float64
+	ClientBurst int
+	// DocumentRate and DocumentBurst limit the changes per second made by
+	// all clients together. Zero means no limit.
+	DocumentRate  float64
+	DocumentBurst int
+	// MaxPending is the number of changes that can be waiting to be applied
+	// at once, further changes are rejected until some have finished.
+	// Zero means no limit.
+	MaxPending int
+}
+
+// Handler wraps 'h' so that changes exceeding the limits are rejected with
+// 429 Too Many Requests, and a Retry-After header saying when to try again,
+// or 503 Service Unavailable when too many are pending.
+func (l RateLimit) Handler(h http.Handler) http.Handler {
+	var mu sync.Mutex
+	clients := map[string]*tokenBucket{}
+	document := &tokenBucket{tokens: float64(l.DocumentBurst), last: time.Now()}
+
+	var pending chan struct{}
+	if l.MaxPending > 0 {
+		pending = make(chan struct{}, l.MaxPending)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		client := clientID(req)
+
+		mu.Lock()
+		now := time.Now()
+		ok, wait := true, time.Duration(0)
+		if l.ClientRate > 0 {
+			b, exists := clients[client]
+			if !exists {
+				if len(clients) >= maxIdleBuckets {
+					sweepBuckets(clients, now, l.ClientRate, float64(l.ClientBurst))
+				}
+				b = &tokenBucket{tokens: float64(l.ClientBurst), last: now}
+				clients[client] = b
+			}
+			ok, wait = b.take(now, l.ClientRate, float64(l.ClientBurst))
+		}
+		if ok && l.DocumentRate > 0 {
+			ok, wait = document.take(now, l.DocumentRate, float64(l.DocumentBurst))
+		}
+		mu.Unlock()
+
+		if !ok {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		if pending != nil {
+			select {
+			case pending <- struct{}{}:
+				defer func() { <-pending }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many pending changes", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, req)
+	})
+}
+
+// clientID identifies the client making a request, by the subject of its
+// verified TLS certificate if it presented one, or its IP address otherwise.
+func clientID(req *http.Request) string {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return "cert:" + req.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return client
+}
+
+// sweepBuckets drops the buckets that have refilled completely, as they are
+// the same as new ones.
+func sweepBuckets(buckets map[string]*tokenBucket, now time.Time, rate, burst float64) {
+	for client, b := range buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(buckets, client)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// referenceModel is a deliberately naive implementation of the same
+// semantics as CRDT, used to check it. Children are plain slices searched
+// from the start, and nothing is cached, so it is slow but easy to see it
+// follows the rules: a newer item goes before older siblings, unknown
+// items wait under the ghost node, deleting an item hands its children to
+// its parent, and moving an item under itself does nothing. It is given the
+// update and delete events in the order every replica ends up applying
+// them in, rather than undoing and redoing them as CRDT does.
+type referenceModel struct {
+	nodes map[string]*referenceNode
+}
+
+type referenceNode struct {
+	key        string
+	parent     *referenceNode
+	children   []*referenceNode
+	clock      VectorClock
+	value      string
+	valueClock VectorClock
+}
+
+func newReferenceModel() *referenceModel {
+	root := &referenceNode{key: rootKey}
+	ghost := &referenceNode{key: ghostKey, parent: root}
+	root.children = []*referenceNode{ghost}
+	return &referenceModel{nodes: map[string]*referenceNode{rootKey: root, ghostKey: ghost}}
+}
+
+func (m *referenceModel) apply(e Event) {
+	item, exists := m.nodes[e.ItemKey]
+
+	switch e.Type {
+	case "update":
+		if !exists {
+			item = m.add(e.ItemKey, e.VectorClock)
+		}
+		if e.VectorClock.Before(item.clock) {
+			return
+		}
+		target, exists := m.nodes[e.TargetItemKey]
+		if !exists {
+			target = m.add(e.TargetItemKey, VectorClock{})
+			m.attach(m.nodes[ghostKey], target)
+		}
+		for n := target; n != nil; n = n.parent {
+			if n == item {
+				if item.parent == nil {
+					item.clock = VectorClock{}
+					m.attach(m.nodes[ghostKey], item)
+				}
+				return
+			}
+		}
+		item.clock = e.VectorClock
+		m.attach(target, item)
+	case "set":
+		if !exists {
+			item = m.add(e.ItemKey, VectorClock{})
+			m.attach(m.nodes[ghostKey], item)
+		}
+		if !laterWrite(e.VectorClock, item.valueClock, strings.Compare(e.Value, item.value)) {
+			return
+		}
+		item.value, item.valueClock = e.Value, e.VectorClock
+	case "priority":
+		// only the default ordering, by clock, is modelled.
+	default:
+		if !exists {
+			item = m.add(e.ItemKey, e.VectorClock)
+		}
+		if e.VectorClock.Before(item.clock) {
+			return
+		}
+		if item.parent != nil && item.parent.key != ghostKey {
+			for _, c := range append([]*referenceNode{}, item.children...) {
+				m.attach(item.parent, c)
+			}
+		}
+		item.clock = e.VectorClock
+		m.attach(m.nodes[ghostKey], item)
+	}
+}
+
+func (m *referenceModel) add(key string, clock VectorClock) *referenceNode {
+	n := &referenceNode{key: key, clock: clock}
+	m.nodes[key] = n
+	return n
+}
+
+// attach moves 'child' to before the first of the children of 'parent' that
+// is older than it, the ghost node always staying first.
+func (m *referenceModel) attach(parent, child *referenceNode) {
+	if old := child.parent; old != nil {
+		for i, c := range old.children {
+			if c == child {
+				old.children = append(old.children[:i], old.children[i+1:]...)
+				break
+			}
+		}
+	}
+
+	i := 0
+	for i < len(parent.children) && (parent.children[i].key == ghostKey || !m.newer(child, parent.children[i])) {
+		i++
+	}
+	parent.children = append(parent.children[:i], append([]*referenceNode{child}, parent.children[i:]...)...)
+	child.parent = parent
+}
+
+// newer checks whether 'a' is newer than 'b': its clock has a larger sum,
+// or the same sum and a larger canonical clock, or the same clock and a
+// smaller key.
+func (m *referenceModel) newer(a, b *referenceNode) bool {
+	sa, sb := 0, 0
+	for _, t := range a.clock {
+		sa += t
+	}
+	for _, t := range b.clock {
+		sb += t
+	}
+	if sa != sb {
+		return sa > sb
+	}
+	if ca, cb := canonicalClock(a.clock), canonicalClock(b.clock); ca != cb {
+		return ca > cb
+	}
+	return a.key < b.key
+}
+
+// document returns the document in the same form as document does for a
+// CRDT, visiting the nodes in the same order as Traverse.
+func (m *referenceModel) document() string {
+	keys := []string{}
+	var visit func(n *referenceNode)
+	visit = func(n *referenceNode) {
+		if !(n.key == rootKey || n.key == ghostKey || n.parent.key == ghostKey) {
+			keys = append(keys, documentKey(n.key, n.value))
+		}
+		for _, c := range n.children {
+			visit(c)
+		}
+	}
+	visit(m.nodes[rootKey])
+	return strings.Join(keys, ",")
+}
+
+// CheckReference applies the events in order to a CRDT and to a naive
+// reference implementation of the same semantics, and returns an error if
+// their documents differ.
+func CheckReference(events []Event) error {
+	crdt := NewCRDT()
+	for _, e := range events {
+		crdt.Apply(e)
+	}
+	return diffReference(crdt, events)
+}
+
+// diffReference compares the document of the CRDT with the one the
+// reference implementation makes from the events the CRDT applied.
+func diffReference(crdt *CRDT, events []Event) error {
+	sorted := append([]Event{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return moveBefore(sorted[i], sorted[j])
+	})
+	m := newReferenceModel()
+	for _, e := range sorted {
+		m.apply(e)
+	}
+	if got, want := document(crdt), m.document(); got != want {
+		return fmt.Errorf("document differs from reference after %d events:\ngot:  %s\nwant: %s", len(events), got, want)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const replHelp = `commands:
+  insert <item> under <target>   add a new item after target
+  move <item> under <target>     move an existing item after target
+  delete <item>                  delete an item
+  set <item> <value>             set the value of an item
+  show                           print the tree and the ordering
+  stats                          print statistics about the tree
+  memory                         print the estimated memory used by the tree
+  undo                           reverse the last local operation
+  help                           print this message
+  quit                           exit
+'root' can be used as the target to refer to the root of the tree.`
+
+// runREPL runs an interactive session against a live replica.
+func runREPL(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	id := fs.Int("id", 1, "client id used in the vector clocks of generated events")
+	inspect := fs.String("inspect", "", "address to serve the web inspector on, e.g. localhost:8080")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := NewReplica(*id)
+
+	if *inspect != "" {
+		l, err := net.Listen("tcp", *inspect)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		fmt.Printf("inspector running at http://%s\n", l.Addr())
+		go http.Serve(l, NewInspector(r))
+	}
+
+	return repl(r, os.Stdin, os.Stdout)
+}
+
+// repl reads commands line by line from 'in', applies them to the replica,
+// and writes the results to 'out'.
+func repl(r *Replica, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			if fields[0] == "quit" || fields[0] == "exit" {
+				return nil
+			}
+			if err := replCommand(r, fields, out); err != nil {
+				fmt.Fprintf(out, "error: %v\n", err)
+			}
+		}
+		fmt.Fprint(out, "> ")
+	}
+	return scanner.Err()
+}
+
+func replCommand(r *Replica, fields []string, out io.Writer) error {
+	switch fields[0] {
+	case "insert", "move":
+		if len(fields) != 4 || fields[2] != "under" {
+			return fmt.Errorf("usage: %s <item> under <target>", fields[0])
+		}
+		item, target := replKey(fields[1]), replKey(fields[3])
+		if item == rootKey || item == ghostKey {
+			return fmt.Errorf("%s cannot be used as an item", fields[1])
+		}
+		var exists bool
+		r.View(func(crdt *CRDT) {
+			_, exists = crdt.nodes[item]
+		})
+		if fields[0] == "insert" && exists {
+			return fmt.Errorf("%s already exists, use move", item)
+		}
+		if fields[0] == "move" && !exists {
+			return fmt.Errorf("%s does not exist, use insert", item)
+		}
+		e, err := r.TryInsert(item, target)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, replEvent(e))
+	case "delete":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: delete <item>")
+		}
+		item := replKey(fields[1])
+		if item == rootKey || item == ghostKey {
+			return fmt.Errorf("%s cannot be deleted", fields[1])
+		}
+		fmt.Fprintln(out, replEvent(r.Delete(item)))
+	case "set":
+		if len(fields) < 3 {
+			return fmt.Errorf("usage: set <item> <value>")
+		}
+		fmt.Fprintln(out, replEvent(r.Set(replKey(fields[1]), strings.Join(fields[2:], " "))))
+	case "undo":
+		e, ok := r.Undo()
+		if !ok {
+			return fmt.Errorf("nothing to undo")
+		}
+		fmt.Fprintln(out, replEvent(e))
+	case "show":
+		r.View(func(crdt *CRDT) {
+			fmt.Fprint(out, crdt)
+			keys := []string{}
+			for n := range crdt.Traverse() {
+				keys = append(keys, n.key)
+			}
+			fmt.Fprintf(out, "order: %s\n", strings.Join(keys, ","))
+		})
+	case "stats":
+		r.View(func(crdt *CRDT) {
+			fmt.Fprintf(out, "%+v\n", crdt.Stats())
+		})
+	case "memory":
+		r.View(func(crdt *CRDT) {
+			m := crdt.EstimateMemory()
+			fmt.Fprintf(out, "%+v total:%d\n", m, m.Total())
+		})
+	case "help":
+		fmt.Fprintln(out, replHelp)
+	default:
+		return fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+	return nil
+}
+
+// replKey maps the user friendly 'root' onto the root node key.
+func replKey(key string) string {
+	if key == "root" {
+		return rootKey
+	}
+	return key
+}
+
+func replEvent(e Event) string {
+	switch e.Type {
+	case "delete":
+		return fmt.Sprintf("applied: delete %s %v", e.ItemKey, e.VectorClock)
+	case "set":
+		return fmt.Sprintf("applied: set %s = %q %v", e.ItemKey, e.Value, e.VectorClock)
+	}
+	return fmt.Sprintf("applied: update %s -> %s %v", e.ItemKey, e.TargetItemKey, e.VectorClock)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestREPLRejectsCycles(t *testing.T) {
+	r := New(WithID(1))
+	var out strings.Builder
+	err := repl(r, strings.NewReader("insert a under root\ninsert b under a\nmove a under b\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "error: cycle rejected") {
+		t.Errorf("moving a under b wasn't rejected:\n%s", out.String())
+	}
+	r.View(func(crdt *CRDT) {
+		if got := crdt.nodes["a"].parent.key; got != rootKey {
+			t.Errorf("a is under %s, want %s", got, rootKey)
+		}
+	})
+}
+
+func TestTryInsertRejectsCycles(t *testing.T) {
+	r := New(WithID(1))
+	r.Insert("a", rootKey)
+	r.Insert("b", "a")
+	clock := r.Clock()
+
+	if _, err := r.TryInsert("a", "b"); !errors.Is(err, ErrCycleRejected) {
+		t.Errorf("TryInsert gave %v, want %v", err, ErrCycleRejected)
+	}
+	if e := r.Insert("a", "a"); e.Type != "" {
+		t.Errorf("Insert gave %+v, want the zero Event", e)
+	}
+	if got := r.Clock(); !sameClock(got, clock) {
+		t.Errorf("clock is %v after rejected moves, want %v", got, clock)
+	}
+}
+
+func TestUndoDropsMovesMakingACycle(t *testing.T) {
+	r1, r2 := New(WithID(1)), New(WithID(2))
+	for _, e := range []Event{r1.Insert("a", rootKey), r1.Insert("b", "a"), r1.Insert("b", rootKey)} {
+		r2.Apply(e)
+	}
+	// undoing the last move would put b back under a, which r2 has since
+	// moved under b.
+	r1.Apply(r2.Insert("a", "b"))
+
+	e, ok := r1.Undo()
+	if !ok {
+		t.Fatal("nothing to undo")
+	}
+	if e.Type != "delete" || e.ItemKey != "b" {
+		t.Errorf("undo gave %s %s, want the insert of b undone", e.Type, e.ItemKey)
+	}
+	r1.View(func(crdt *CRDT) {
+		if err := crdt.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
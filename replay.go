@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReplayStep is the application of one event by a Replayer.
+type ReplayStep struct {
+	// Index is the position of the event in the log, from 0.
+	Index int
+	Event Event
+	// Applied is false if the event was stale and discarded.
+	Applied bool
+	// Diff is the lines of the printed tree the event removed, prefixed
+	// with "- ", and added, prefixed with "+ ".
+	Diff []string
+}
+
+// Replayer applies a log of events to a CRDT one step at a time, to see how
+// each event changes the tree.
+type Replayer struct {
+	// Printer prints the tree the diffs are made of.
+	Printer Printer
+
+	crdt   *CRDT
+	events []Event
+	next   int
+}
+
+// NewReplayer returns a Replayer of the events, with none applied yet.
+func NewReplayer(events []Event) *Replayer {
+	return &Replayer{crdt: NewCRDT(), events: events}
+}
+
+// CRDT returns the CRDT the events are applied to.
+func (p *Replayer) CRDT() *CRDT {
+	return p.crdt
+}
+
+// Done checks whether every event has been applied.
+func (p *Replayer) Done() bool {
+	return p.next == len(p.events)
+}
+
+// Step applies the next event, returning false if there are none left.
+func (p *Replayer) Step() (ReplayStep, bool) {
+	if p.Done() {
+		return ReplayStep{}, false
+	}
+	step := ReplayStep{Index: p.next, Event: p.events[p.next]}
+	p.next++
+
+	before := p.Printer.Print(p.crdt)
+	step.Applied = p.crdt.apply(step.Event)
+	step.Diff = diffLines(before, p.Printer.Print(p.crdt))
+	return step, true
+}
+
+// Continue steps until 'stop' returns true for a step, returning that step,
+// or until the events run out, returning false. Every step, including the
+// one stopped at, is passed to 'fn' if it isn't nil.
+func (p *Replayer) Continue(stop func(ReplayStep) bool, fn func(ReplayStep)) (ReplayStep, bool) {
+	for {
+		step, ok := p.Step()
+		if !ok {
+			return step, false
+		}
+		if fn != nil {
+			fn(step)
+		}
+		if stop != nil && stop(step) {
+			return step, true
+		}
+	}
+}
+
+// BreakAt returns a stop function for Continue, stopping at the event with
+// the index, and at every event on an item with one of the keys.
+func BreakAt(index int, keys ...string) func(ReplayStep) bool {
+	return func(step ReplayStep) bool {
+		if step.Index == index {
+			return true
+		}
+		for _, key := range keys {
+			if step.Event.ItemKey == key {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// diffLines returns the lines removed from 'a' and added in 'b', in the
+// order of a longest common subsequence of their lines.
+func diffLines(a, b string) []string {
+	la, lb := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of la[i:]
+	// and lb[j:].
+	lcs := make([][]int, len(la)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lb)+1)
+	}
+	for i := len(la) - 1; i >= 0; i-- {
+		for j := len(lb) - 1; j >= 0; j-- {
+			if la[i] == lb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(la) || j < len(lb) {
+		switch {
+		case i < len(la) && j < len(lb) && la[i] == lb[j]:
+			i++
+			j++
+		case i < len(la) && (j == len(lb) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+la[i])
+			i++
+		default:
+			diff = append(diff, "+ "+lb[j])
+			j++
+		}
+	}
+	return diff
+}
+
+// runReplay replays a log of events, read as newline delimited JSON like
+// watch, printing each event and how it changed the tree. With -step, or
+// once a breakpoint set with -at or -keys is hit, it pauses for a command
+// from stdin: enter steps to the next event, c continues to the next
+// breakpoint, p prints the tree and q quits.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := fs.String("f", "-", "file to read events from, - for stdin")
+	at := fs.Int("at", -1, "pause at the event with this index, counting from 0")
+	keyList := fs.String("keys", "", "comma separated keys of items to pause at every event on")
+	stepping := fs.Bool("step", false, "pause after every event")
+	var printer Printer
+	fs.BoolVar(&printer.ShowValues, "values", false, "show the value of each node")
+	fs.BoolVar(&printer.HideClocks, "no-clocks", false, "hide the clock of each node")
+	fs.BoolVar(&printer.HideGhost, "no-ghost", false, "hide deleted nodes and unknown targets")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var keys []string
+	if *keyList != "" {
+		keys = strings.Split(*keyList, ",")
+	}
+	pausing := *stepping || *at >= 0 || len(keys) > 0
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	} else if pausing {
+		return fmt.Errorf("pausing reads commands from stdin, so the events must come from a file, use -f")
+	}
+
+	events := []Event{}
+	dec := json.NewDecoder(in)
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		events = append(events, e)
+	}
+
+	p := NewReplayer(events)
+	p.Printer = printer
+	out := os.Stdout
+	show := func(step ReplayStep) {
+		fmt.Fprintf(out, "#%d %s %s -> %s %q (%s)", step.Index, step.Event.Type, step.Event.ItemKey, step.Event.TargetItemKey, step.Event.Value, canonicalClock(step.Event.VectorClock))
+		if !step.Applied {
+			fmt.Fprint(out, " (stale)")
+		}
+		fmt.Fprintln(out)
+		for _, line := range step.Diff {
+			fmt.Fprintln(out, "  "+line)
+		}
+	}
+
+	breakpoint := BreakAt(*at, keys...)
+	stop := breakpoint
+	if *stepping {
+		stop = func(ReplayStep) bool { return true }
+	}
+	commands := bufio.NewScanner(os.Stdin)
+	for {
+		if _, paused := p.Continue(stop, show); !paused {
+			fmt.Fprintf(out, "%d events replayed\n", len(events))
+			return nil
+		}
+
+		for paused := true; paused; {
+			fmt.Fprint(out, "(replay) ")
+			if !commands.Scan() {
+				return commands.Err()
+			}
+			switch strings.TrimSpace(commands.Text()) {
+			case "", "s":
+				stop = func(ReplayStep) bool { return true }
+				paused = false
+			case "c":
+				stop = breakpoint
+				paused = false
+			case "p":
+				fmt.Fprint(out, p.Printer.Print(p.CRDT()))
+			case "q":
+				return nil
+			default:
+				fmt.Fprintln(out, "commands: enter or s to step, c to continue, p to print the tree, q to quit")
+			}
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentEventsSize is the number of most recently applied events returned
+// by RecentEvents.
+const recentEventsSize = 50
+
+// Replica is a single participant editing a CRDT. It owns the local copy of
+// the CRDT along with the vector clock of everything it has seen, and turns
+// local operations into events that can be sent to other replicas.
+// A Replica is safe for concurrent use.
+type Replica struct {
+	id int
+
+	mu    sync.Mutex
+	clock VectorClock
+	crdt  *CRDT
+	// undo is the stack of events reversing the local events generated
+	// by this replica, most recent last. They get their clock when used.
+	undo []Event
+	// feed holds every applied event, local or remote.
+	feed *Feed
+	// keys is the number of keys generated by NewKey.
+	keys int
+	// metrics, if set, records every applied event.
+	metrics *Metrics
+	// audit, if set, records who made every applied event.
+	audit *AuditLog
+	// metadata is added to every local event.
+	metadata map[string]string
+	// timestamps is whether local events get a Timestamp.
+	timestamps bool
+	// guard, if set, checks the clocks of received events.
+	guard *ClockGuard
+	// acks holds the clock acknowledged by each peer, and when it was last
+	// acknowledged, and stable the clock they have all acknowledged.
+	acks     map[int]VectorClock
+	ackedAt  map[int]time.Time
+	stable   VectorClock
+	onStable func(VectorClock)
+	// ghosts holds when each unknown target was first seen.
+	ghosts map[string]time.Time
+	// quota limits the size of the document.
+	quota Quota
+	// validator, if set, checks the values of set events.
+	validator func(itemKey, value string) error
+	// quarantine holds the rejected events, oldest first.
+	quarantine []QuarantinedEvent
+	// expiries holds when items inserted with InsertExpiring expire.
+	expiries map[string]expiry
+	// warnings are the thresholds of the warnings logged by apply, and
+	// actorsWarned the number of actors last warned about.
+	warnings     Warnings
+	actorsWarned int
+	// authorize, if set, decides whether received events are allowed.
+	authorize func(actor int, e Event) error
+	// closed is set by Close.
+	closed bool
+	// batch, if set, collects local events to broadcast.
+	batch *batcher
+}
+
+// NewReplica returns a Replica with an empty CRDT, generating events as
+// client 'id'.
+func NewReplica(id int) *Replica {
+	return &Replica{
+		id:    id,
+		clock: VectorClock{},
+		crdt:  NewCRDT(),
+		feed:  NewFeed(),
+	}
+}
+
+// RestoreReplica rebuilds replica 'id' from the events it had applied, in
+// the order it applied them, e.g. from its feed after a restart. Keys made
+// by NewKey carry on from the highest one in the events, so they aren't
+// reused.
+func RestoreReplica(id int, events []Event) *Replica {
+	r := NewReplica(id)
+	r.restore(events)
+	return r
+}
+
+// restore applies the events the replica had applied, see RestoreReplica.
+func (r *Replica) restore(events []Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prefix := strconv.Itoa(r.id) + "."
+	for _, e := range events {
+		r.clock.Merge(e.VectorClock)
+		r.apply(e)
+		if n, err := strconv.Atoi(strings.TrimPrefix(e.ItemKey, prefix)); err == nil && strings.HasPrefix(e.ItemKey, prefix) && n > r.keys {
+			r.keys = n
+		}
+	}
+}
+
+// ID returns the client id of the replica.
+func (r *Replica) ID() int {
+	return r.id
+}
+
+// NewKey returns a new item key, unique to this replica.
+func (r *Replica) NewKey() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys++
+	return fmt.Sprintf("%d.%d", r.id, r.keys)
+}
+
+// SetMetrics sets the Metrics that record the events applied to the replica.
+func (r *Replica) SetMetrics(m *Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = m
+}
+
+// SetAudit sets the AuditLog that records the events applied to the replica.
+func (r *Replica) SetAudit(a *AuditLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audit = a
+}
+
+// SetMetadata sets the metadata added to every event generated by the
+// replica, e.g. the device or session it belongs to.
+func (r *Replica) SetMetadata(metadata map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metadata = metadata
+}
+
+// SetTimestamps sets whether the events generated by the replica carry the
+// time they were made.
+func (r *Replica) SetTimestamps(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timestamps = enabled
+}
+
+// SetClockGuard sets the ClockGuard checking the clocks of the events
+// received by Apply.
+func (r *Replica) SetClockGuard(g *ClockGuard) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.guard = g
+}
+
+// SetLogger sets the logger of the replica's CRDT.
+func (r *Replica) SetLogger(logger *slog.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.crdt.SetLogger(logger.With("replica", r.id))
+}
+
+// Metrics returns the Metrics set on the replica, or nil.
+func (r *Replica) Metrics() *Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metrics
+}
+
+// View calls 'fn' with the replica's local copy of the CRDT. No events are
+// applied while 'fn' runs, and the CRDT must not be used after it returns.
+func (r *Replica) View(fn func(crdt *CRDT)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.crdt)
+}
+
+// Clock returns a copy of the latest vector clock the replica knows about.
+func (r *Replica) Clock() VectorClock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clock.Copy()
+}
+
+// RecentEvents returns the most recently applied events, oldest first.
+func (r *Replica) RecentEvents() []Event {
+	events := []Event{}
+	for _, entry := range r.feed.Tail(recentEventsSize) {
+		events = append(events, entry.Event)
+	}
+	return events
+}
+
+// Feed returns the feed of every event applied to the replica, local or
+// remote, in the order they were applied.
+func (r *Replica) Feed() *Feed {
+	return r.feed
+}
+
+// Insert generates and applies an event placing 'itemKey' under 'targetKey'.
+// It is used both for new items and for moving existing ones. Moves that
+// would put the item under itself are rejected, and the zero Event is
+// returned, see TryInsert.
+func (r *Replica) Insert(itemKey, targetKey string) Event {
+	e, _ := r.TryInsert(itemKey, targetKey)
+	return e
+}
+
+// TryInsert is Insert, returning an error wrapping ErrCycleRejected, and no
+// event, if 'targetKey' is 'itemKey' or one of its descendants.
+func (r *Replica) TryInsert(itemKey, targetKey string) (Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := Event{Type: "update", ItemKey: itemKey, TargetItemKey: targetKey}
+	if err := r.checkCycle(e); err != nil {
+		return Event{}, err
+	}
+	r.pushUndoPosition(itemKey)
+	return r.local(e), nil
+}
+
+// Delete generates and applies an event deleting 'itemKey'.
+func (r *Replica) Delete(itemKey string) Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushUndoPosition(itemKey)
+	return r.local(Event{Type: "delete", ItemKey: itemKey})
+}
+
+// Set generates and applies an event setting the value of 'itemKey'.
+func (r *Replica) Set(itemKey, value string) Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushUndoValue(itemKey)
+	return r.local(Event{Type: "set", ItemKey: itemKey, Value: value})
+}
+
+// Undo generates and applies an event reversing the most recent local
+// operation that hasn't been undone yet. Moves that can't be undone any
+// more, because the item's old parent has since been moved under it, are
+// dropped. It returns false if there is nothing left to undo.
+func (r *Replica) Undo() (Event, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var e Event
+	for {
+		if len(r.undo) == 0 {
+			return Event{}, false
+		}
+		e = r.undo[len(r.undo)-1]
+		r.undo = r.undo[:len(r.undo)-1]
+		if r.checkCycle(e) == nil {
+			break
+		}
+	}
+
+	// undoing is just another local operation, so it gets a fresh clock
+	// and is replicated like any other event.
+	return r.local(e), true
+}
+
+// Apply applies an event received from another replica, merging its vector
+// clock into the replica's clock so local events happen after it. Events
+// rejected by the ClockGuard, Quota or value validator, if there are any,
+// are put in the quarantine instead.
+func (r *Replica) Apply(e Event) {
+	r.TryApply(e)
+}
+
+// TryApply is Apply, returning an EventError if the event was rejected, or
+// discarded as stale.
+func (r *Replica) TryApply(e Event) error {
+	// peers can acknowledge events before this replica has them, which
+	// become stable once it does. The handler is called once unlocked.
+	var stable VectorClock
+	var onStable func(VectorClock)
+	defer func() {
+		if onStable != nil {
+			onStable(stable)
+		}
+	}()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return &EventError{Event: e, Err: ErrClosed}
+	}
+	if r.guard != nil {
+		if err := r.guard.check(r.id, r.clock, e, r.crdt.logger); err != nil {
+			return r.reject(e, err)
+		}
+	}
+	actor := eventActor(r.clock, e.VectorClock)
+	if r.authorize != nil {
+		if err := r.authorize(actor, e); err != nil {
+			return r.reject(e, fmt.Errorf("%w: %v", ErrUnauthorized, err))
+		}
+	}
+	if err := r.check(e); err != nil {
+		return r.reject(e, err)
+	}
+	r.clock.Merge(e.VectorClock)
+	applied := r.apply(e)
+	if r.audit != nil {
+		r.audit.Record(e, actor, time.Now())
+	}
+	if len(r.acks) > 0 {
+		var advanced bool
+		if stable, advanced = r.updateStable(); advanced {
+			onStable = r.onStable
+		}
+	}
+	if !applied {
+		return &EventError{Event: e, Err: ErrStaleEvent}
+	}
+	return nil
+}
+
+// Close shuts the replica down: local events waiting to be batched are
+// sent, subscriptions to its feed end, and events received from then on are
+// rejected with ErrClosed, without being put in the quarantine. The
+// document can still be read. Closing a closed replica does nothing.
+func (r *Replica) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.FlushBatch()
+	r.feed.Close()
+}
+
+// reject records that the event wasn't applied because of 'err', and
+// returns it. r.mu must be held.
+func (r *Replica) reject(e Event, err error) error {
+	r.crdt.logger.Warn("event rejected", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock, "error", err)
+	r.quarantineEvent(e, err)
+	return &EventError{Event: e, Err: err}
+}
+
+// local stamps the event with the next time of this replica and applies it.
+func (r *Replica) local(e Event) Event {
+	r.clock[r.id]++
+	e.VectorClock = r.clock.Copy()
+	if r.timestamps {
+		e.Timestamp = time.Now().UnixMilli()
+	}
+	if len(r.metadata) > 0 && e.Metadata == nil {
+		e.Metadata = make(map[string]string, len(r.metadata))
+		for k, v := range r.metadata {
+			e.Metadata[k] = v
+		}
+	}
+	r.apply(e)
+	if r.audit != nil {
+		r.audit.Record(e, r.id, time.Now())
+	}
+	if r.batch != nil {
+		r.batchEvent(e)
+	}
+	return e
+}
+
+func (r *Replica) apply(e Event) bool {
+	fanout, before := r.fanout(e)
+	start := time.Now()
+	applied := r.crdt.apply(e)
+	took := time.Since(start)
+	if r.metrics != nil {
+		r.metrics.observeApply(e, applied, took)
+	}
+	r.warn(e, took, fanout, before)
+	r.trackGhosts(e, start)
+	r.trackExpiry(e)
+
+	// the feed keeps every event, so share the key strings with the nodes
+	// rather than keeping a copy of them per event.
+	e.ItemKey = r.crdt.intern(e.ItemKey)
+	e.TargetItemKey = r.crdt.intern(e.TargetItemKey)
+	r.feed.Append(e)
+	return applied
+}
+
+// pushUndoValue remembers the current value of 'itemKey' so that the next
+// local operation setting it can be reversed.
+func (r *Replica) pushUndoValue(itemKey string) {
+	undo := Event{Type: "set", ItemKey: itemKey}
+	if n, exists := r.crdt.nodes[itemKey]; exists {
+		undo.Value = n.value
+	}
+	r.undo = append(r.undo, undo)
+}
+
+// pushUndoPosition remembers where 'itemKey' currently is so that the next
+// local operation moving or deleting it can be reversed.
+func (r *Replica) pushUndoPosition(itemKey string) {
+	// items that don't exist yet, or are deleted or unknown (under the ghost),
+	// have no position to go back to so are deleted instead.
+	undo := Event{Type: "delete", ItemKey: itemKey}
+	if n, exists := r.crdt.nodes[itemKey]; exists && n.parent != nil && n.parent.key != ghostKey {
+		undo = Event{Type: "update", ItemKey: itemKey, TargetItemKey: n.parent.key}
+	}
+	r.undo = append(r.undo, undo)
+}
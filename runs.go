@@ -0,0 +1,90 @@
+package main
+
+// Run is a compact encoding of a sequence of events, such as a client
+// typing, where each item is inserted after the previous one and each
+// event's clock is the previous one with the same actor's entry increased
+// by one. Those sequences only need the item keys to be stored, every other
+// field can be derived from the first event.
+//
+// A Run with no Items is just its Event, and encodes to the same JSON.
+type Run struct {
+	Event
+	// Actor is the client id whose clock entry increases by one for each
+	// of the Items.
+	Actor int `json:",omitempty"`
+	// Items are the keys of the items inserted, in order, after the item
+	// of Event.
+	Items []string `json:",omitempty"`
+}
+
+// Events returns the events encoded by the run.
+func (run Run) Events() []Event {
+	events := make([]Event, 0, 1+len(run.Items))
+	events = append(events, run.Event)
+
+	prev := run.Event
+	for _, key := range run.Items {
+		clock := prev.VectorClock.Copy()
+		clock[run.Actor]++
+		prev = Event{Type: "update", VectorClock: clock, ItemKey: key, TargetItemKey: prev.ItemKey, Timestamp: run.Timestamp}
+		events = append(events, prev)
+	}
+	return events
+}
+
+// EncodeRuns groups the events into runs, keeping their order.
+func EncodeRuns(events []Event) []Run {
+	runs := []Run{}
+	var prev Event
+	for _, e := range events {
+		if len(runs) > 0 {
+			run := &runs[len(runs)-1]
+			if actor, ok := continuesRun(prev, e); ok && (len(run.Items) == 0 || actor == run.Actor) {
+				run.Actor = actor
+				run.Items = append(run.Items, e.ItemKey)
+				prev = e
+				continue
+			}
+		}
+		runs = append(runs, Run{Event: e})
+		prev = e
+	}
+	return runs
+}
+
+// DecodeRuns returns the events encoded by the runs.
+func DecodeRuns(runs []Run) []Event {
+	events := []Event{}
+	for _, run := range runs {
+		events = append(events, run.Events()...)
+	}
+	return events
+}
+
+// continuesRun checks whether 'e' inserts its item after the item of 'prev'
+// with the clock of 'prev' ticked by a single actor, returning that actor.
+func continuesRun(prev, e Event) (int, bool) {
+	// events in a run are rebuilt from the first one, so events with
+	// metadata of their own, or made at another time, can't join it.
+	if prev.Type != "update" || e.Type != "update" || e.Value != "" || len(e.Metadata) > 0 || e.Timestamp != prev.Timestamp || e.TargetItemKey != prev.ItemKey {
+		return 0, false
+	}
+	if len(e.VectorClock) != len(prev.VectorClock) {
+		return 0, false
+	}
+
+	actor, ticked := 0, false
+	for id, dt := range e.VectorClock {
+		prevDT, exists := prev.VectorClock[id]
+		switch {
+		case !exists:
+			return 0, false
+		case dt == prevDT:
+		case dt == prevDT+1 && !ticked:
+			actor, ticked = id, true
+		default:
+			return 0, false
+		}
+	}
+	return actor, ticked
+}
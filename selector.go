@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Selector is a compiled structural query over a document, see
+// ParseSelector and ParsePath.
+type Selector struct {
+	query string
+	steps []queryStep
+}
+
+// queryAxis is where a query step looks for nodes, relative to the nodes
+// matched by the step before.
+type queryAxis int
+
+const (
+	axisChild queryAxis = iota
+	axisDescendant
+	axisDescendantOrSelf
+)
+
+// queryStep is a step of a compiled query: the nodes along its axis from
+// each node matched so far that pass its test.
+type queryStep struct {
+	axis queryAxis
+	test func(n *node) bool
+	// key, if set, is the key the test requires, so the step can be looked
+	// up in the index of nodes rather than walked to.
+	key string
+	// position, if set, picks amongst the nodes a step matches from each
+	// node, given their number, returning the index to keep or -1.
+	position func(count int) int
+}
+
+// ParseSelector compiles a selector, in a syntax along the lines of CSS:
+//
+//	root            the root node
+//	*               any node
+//	"text"          nodes whose value is the text
+//	#key            the node with the key
+//	[field=value]   nodes whose value is a JSON object with the field set to
+//	                the value, or with [field] just having the field
+//	children        the children of the nodes matched so far
+//	descendants     the descendants of the nodes matched so far
+//
+// A step is made of a test optionally followed by predicates, or of
+// predicates only, which then apply to any node. Steps separated by '>'
+// match children of the nodes matched by the step before, and steps
+// separated by spaces match descendants. So
+//
+//	root > "projects" [status=todo]
+//
+// matches the nodes with a "todo" status anywhere under the children of the
+// root with the value "projects".
+func ParseSelector(query string) (*Selector, error) {
+	p := &selectorParser{query: query}
+	steps, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", query, err)
+	}
+	return &Selector{query: query, steps: steps}, nil
+}
+
+// String returns the selector as it was written.
+func (s *Selector) String() string {
+	return s.query
+}
+
+// Select returns the keys of the nodes matching the selector, in document
+// order.
+func (crdt *CRDT) Select(s *Selector) []string {
+	return crdt.query(s.steps)
+}
+
+// Select returns the keys of the nodes matching the selector, see
+// CRDT.Select.
+func (r *Replica) Select(s *Selector) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crdt.Select(s)
+}
+
+// query runs the steps from the root, returning the keys of the matched
+// nodes in document order.
+func (crdt *CRDT) query(steps []queryStep) []string {
+	root := crdt.nodes[rootKey]
+	matched := map[*node]bool{root: true}
+	for i, step := range steps {
+		next := map[*node]bool{}
+		if step.key != "" && i == 0 && step.position == nil && step.axis == axisDescendantOrSelf {
+			// the first step of the query searches the whole document, so
+			// the index finds the node straight away.
+			if n, exists := crdt.nodes[step.key]; exists && crdt.inDocument(n) && step.test(n) {
+				next[n] = true
+			}
+			matched = next
+			continue
+		}
+		for n := range matched {
+			var found []*node
+			crdt.along(n, step.axis, func(c *node) {
+				if step.test(c) {
+					found = append(found, c)
+				}
+			})
+			if step.position != nil {
+				if i := step.position(len(found)); i >= 0 && i < len(found) {
+					found = found[i : i+1]
+				} else {
+					found = nil
+				}
+			}
+			for _, c := range found {
+				next[c] = true
+			}
+		}
+		matched = next
+	}
+
+	keys := []string{}
+	if matched[root] {
+		keys = append(keys, rootKey)
+	}
+	for n := crdt.After(root); n != nil && len(keys) < len(matched); n = crdt.After(n) {
+		if matched[n] {
+			keys = append(keys, n.key)
+		}
+	}
+	return keys
+}
+
+// along calls fn with the nodes along the axis from 'n', in document order.
+func (crdt *CRDT) along(n *node, axis queryAxis, fn func(*node)) {
+	if axis == axisDescendantOrSelf {
+		fn(n)
+	}
+	for _, c := range n.children.slice() {
+		if c.key == ghostKey {
+			continue
+		}
+		fn(c)
+		if axis != axisChild {
+			crdt.along(c, axisDescendant, fn)
+		}
+	}
+}
+
+// inDocument checks whether the node is the root or one of its
+// descendants, rather than deleted or waiting under the ghost node.
+func (crdt *CRDT) inDocument(n *node) bool {
+	for ; n.key != rootKey; n = n.parent {
+		if n.parent == nil || n.key == ghostKey {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldTest returns a test for nodes whose value is a JSON object with the
+// field, set to the value if 'hasValue' is set. Values that aren't strings
+// are compared in their JSON form, so [done=true] matches a boolean.
+func fieldTest(field, value string, hasValue bool) func(*node) bool {
+	return func(n *node) bool {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(n.value), &object); err != nil {
+			return false
+		}
+		raw, exists := object[field]
+		if !exists || !hasValue {
+			return exists
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s == value
+		}
+		return string(raw) == value
+	}
+}
+
+// selectorParser parses a selector into query steps.
+type selectorParser struct {
+	query string
+	pos   int
+}
+
+func (p *selectorParser) parse() ([]queryStep, error) {
+	var steps []queryStep
+	// the first step searches the whole document, including the root.
+	axis := axisDescendantOrSelf
+	for {
+		spaced := p.skipSpace()
+		if p.pos == len(p.query) {
+			break
+		}
+		if len(steps) > 0 {
+			switch {
+			case p.query[p.pos] == '>':
+				p.pos++
+				p.skipSpace()
+				axis = axisChild
+			case spaced:
+				axis = axisDescendant
+			default:
+				return nil, fmt.Errorf("expected space or '>' at %d", p.pos)
+			}
+		}
+
+		step, err := p.step(axis)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return steps, nil
+}
+
+// step parses a test and its predicates.
+func (p *selectorParser) step(axis queryAxis) (queryStep, error) {
+	step := queryStep{axis: axis}
+	tests := []func(*node) bool{}
+
+	switch word := p.word(); {
+	case p.pos < len(p.query) && p.query[p.pos] == '"':
+		text, err := p.quoted()
+		if err != nil {
+			return step, err
+		}
+		tests = append(tests, func(n *node) bool { return n.value == text })
+	case p.pos < len(p.query) && p.query[p.pos] == '*':
+		p.pos++
+	case p.pos < len(p.query) && p.query[p.pos] == '#':
+		p.pos++
+		key := p.word()
+		if key == "" {
+			return step, fmt.Errorf("expected a key at %d", p.pos)
+		}
+		p.pos += len(key)
+		step.key = key
+		tests = append(tests, func(n *node) bool { return n.key == key })
+	case word == "root":
+		p.pos += len(word)
+		tests = append(tests, func(n *node) bool { return n.key == rootKey })
+	case word == "children" || word == "descendants":
+		// only valid in place of a test, as shorthand for "> *" and " *".
+		p.pos += len(word)
+		if axis == axisDescendantOrSelf {
+			return step, fmt.Errorf("%s needs a step before it", word)
+		}
+		step.axis = axisDescendant
+		if word == "children" {
+			step.axis = axisChild
+		}
+	case word != "":
+		return step, fmt.Errorf("unknown step %q at %d", word, p.pos)
+	case p.pos < len(p.query) && p.query[p.pos] == '[':
+	default:
+		return step, fmt.Errorf("unexpected %q at %d", p.query[p.pos], p.pos)
+	}
+
+	for p.pos < len(p.query) && p.query[p.pos] == '[' {
+		p.pos++
+		field := p.word()
+		if field == "" {
+			return step, fmt.Errorf("expected a field at %d", p.pos)
+		}
+		p.pos += len(field)
+		value, hasValue := "", false
+		if p.pos < len(p.query) && p.query[p.pos] == '=' {
+			p.pos++
+			hasValue = true
+			if p.pos < len(p.query) && p.query[p.pos] == '"' {
+				var err error
+				if value, err = p.quoted(); err != nil {
+					return step, err
+				}
+			} else {
+				value = p.word()
+				p.pos += len(value)
+			}
+		}
+		if p.pos == len(p.query) || p.query[p.pos] != ']' {
+			return step, fmt.Errorf("expected ']' at %d", p.pos)
+		}
+		p.pos++
+		tests = append(tests, fieldTest(field, value, hasValue))
+	}
+
+	step.test = func(n *node) bool {
+		for _, test := range tests {
+			if !test(n) {
+				return false
+			}
+		}
+		return true
+	}
+	return step, nil
+}
+
+// skipSpace skips any spaces, returning whether there were some.
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.query) && unicode.IsSpace(rune(p.query[p.pos])) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// word returns the word at the position, without consuming it.
+func (p *selectorParser) word() string {
+	end := p.pos
+	for end < len(p.query) && !unicode.IsSpace(rune(p.query[end])) && !strings.ContainsRune(`>[]="#*`, rune(p.query[end])) {
+		end++
+	}
+	return p.query[p.pos:end]
+}
+
+// quoted consumes the quoted string at the position, returning it unquoted.
+func (p *selectorParser) quoted() (string, error) {
+	end := p.pos + 1
+	for end < len(p.query) && p.query[end] != '"' {
+		if p.query[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.query) {
+		return "", fmt.Errorf("unterminated string at %d", p.pos)
+	}
+	s, err := strconv.Unquote(p.query[p.pos : end+1])
+	if err != nil {
+		return "", fmt.Errorf("invalid string at %d: %v", p.pos, err)
+	}
+	p.pos = end + 1
+	return s, nil
+}
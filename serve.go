@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// runServe runs an HTTP server hosting a replica, exposing the resource API
+// under /nodes, the traversal stream under /traverse, the change feed under
+// /feed, event submission under /events, rejected events under /quarantine,
+// read-only published views under /views, the web inspector under /inspect,
+// metrics under /metrics and the replica's stats under /debug/crdt. /healthz
+// and /readyz are served for liveness and readiness probes, and the server
+// shuts down gracefully on SIGINT or SIGTERM. With a client CA, clients must
+// present a certificate it signed, and are rate limited by the certificate's
+// subject. Requests to /nodes and /traverse carrying a session token wait
+// for the replica to catch up with it. Events submitted to /events can be
+// replicated to peer servers, sharing a secret, and only acknowledged once a
+// quorum of them applied them.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	id := fs.Int("id", 1, "client id used in the vector clocks of generated events")
+	debug := fs.Bool("debug", false, "log debug messages")
+	profile := fs.Bool("pprof", false, "serve profiles under /debug/pprof")
+	timestamps := fs.Bool("timestamps", false, "record the time generated events were made")
+	var limit RateLimit
+	fs.Float64Var(&limit.ClientRate, "client-rate", 0, "changes per second allowed per client, 0 for no limit")
+	fs.IntVar(&limit.ClientBurst, "client-burst", 10, "burst of changes allowed per client")
+	fs.Float64Var(&limit.DocumentRate, "document-rate", 0, "changes per second allowed for the document, 0 for no limit")
+	fs.IntVar(&limit.DocumentBurst, "document-burst", 100, "burst of changes allowed for the document")
+	fs.IntVar(&limit.MaxPending, "max-pending", 0, "changes that can wait to be applied at once, 0 for no limit")
+	certFile := fs.String("tls-cert", "", "certificate file to serve TLS with")
+	keyFile := fs.String("tls-key", "", "key file to serve TLS with")
+	clientCAFile := fs.String("tls-client-ca", "", "CA certificates file to require and verify client certificates with")
+	drain := fs.Duration("drain", 5*time.Second, "time to keep serving while unready before shutting down")
+	peers := fs.String("peers", "", "comma separated base URLs of peer servers to replicate submitted events to")
+	var quorum Quorum
+	fs.IntVar(&quorum.Size, "quorum", 0, "peers that must apply submitted events before they are acknowledged")
+	fs.DurationVar(&quorum.Timeout, "quorum-timeout", 5*time.Second, "time to wait for the quorum of peers")
+	fs.StringVar(&quorum.Secret, "peer-secret", "", "secret shared by the peers to recognise each other's replicated events, best set with CRDT_PEER_SECRET")
+	var quota Quota
+	fs.IntVar(&quota.MaxNodes, "max-nodes", 0, "most nodes the document can hold, 0 for no limit")
+	fs.IntVar(&quota.MaxDepth, "max-depth", 0, "deepest a node can be, 0 for no limit")
+	fs.IntVar(&quota.MaxChildren, "max-children", 0, "most children a node can have, 0 for no limit")
+	fs.IntVar(&quota.MaxValueSize, "max-value-size", 0, "longest value in bytes, 0 for no limit")
+	var warnings Warnings
+	fs.DurationVar(&warnings.SlowApply, "warn-slow-apply", 0, "log a warning for events taking longer than this to apply, 0 for none")
+	fs.IntVar(&warnings.MaxChildren, "warn-children", 0, "log a warning when a node goes over this many children, 0 for none")
+	fs.IntVar(&warnings.MaxActors, "warn-actors", 0, "log a warning when the clock goes over this many actors, 0 for none")
+	jsonValues := fs.Bool("json-values", false, "only accept JSON node values")
+	sessionWait := fs.Duration("session-wait", 5*time.Second, "time requests wait for the replica to catch up with their session token")
+	fs.String("config", "", "JSON file of flag values, flags and CRDT_ environment variables take precedence")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := loadConfig(fs, "config"); err != nil {
+		return err
+	}
+
+	if *peers != "" {
+		quorum.Peers = strings.Split(*peers, ",")
+	}
+	if quorum.Size > len(quorum.Peers) {
+		return fmt.Errorf("-quorum %d needs at least as many -peers", quorum.Size)
+	}
+	if quorum.Size > 0 && quorum.Secret == "" {
+		return fmt.Errorf("-quorum needs a -peer-secret")
+	}
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+
+	opts := []Option{
+		WithID(*id),
+		WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))),
+		WithMetrics(NewMetrics()),
+		WithQuota(quota),
+		WithWarnings(warnings),
+	}
+	if *timestamps {
+		opts = append(opts, WithTimestamps())
+	}
+	if *jsonValues {
+		opts = append(opts, WithValueValidator(JSONValues))
+	}
+	r := New(opts...)
+
+	api := withVersion(withSession(r, *sessionWait, limit.Handler(NewAPI(r))))
+	mux := http.NewServeMux()
+	mux.Handle("/nodes", api)
+	mux.Handle("/nodes/", api)
+	mux.Handle("/traverse", withVersion(withSession(r, *sessionWait, NewTraversalStream(r))))
+	mux.Handle("/feed", withVersion(NewFeedStream(r.Feed())))
+	mux.Handle("/events", withVersion(limit.Handler(NewEventsHandler(r, &quorum))))
+	mux.Handle("/inspect", NewInspector(r))
+	mux.Handle("/quarantine", NewQuarantineHandler(r))
+	views := NewViewsHandler(NewPublisher(r))
+	mux.Handle("/views", views)
+	mux.Handle("/views/", views)
+	mux.Handle("/metrics", NewMetricsHandler(r))
+	mux.Handle("/debug/crdt", NewDebugHandler(r))
+
+	// the server is ready until it is asked to shut down, at which point it
+	// stays up for a while so that load balancers can notice.
+	var ready atomic.Bool
+	ready.Store(true)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	if *profile {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: mux}
+
+	if (*certFile == "") != (*keyFile == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be used together")
+	}
+	if *clientCAFile != "" {
+		if *certFile == "" {
+			return fmt.Errorf("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		pem, err := os.ReadFile(*clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", *clientCAFile)
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		if *certFile != "" {
+			fmt.Printf("serving replica %d on https://%s\n", *id, *addr)
+			errs <- srv.ListenAndServeTLS(*certFile, *keyFile)
+			return
+		}
+		fmt.Printf("serving replica %d on http://%s\n", *id, *addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	ready.Store(false)
+	time.Sleep(*drain)
+
+	// closing the replica ends the feed streams, which never finish by
+	// themselves, but don't wait on the other requests forever.
+	r.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
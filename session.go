@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sessionHeader carries a session token, sent by clients with the clock of
+// what they have written or read, and by the server with the clock of what
+// it served them.
+const sessionHeader = "CRDT-Session"
+
+// EncodeSessionToken returns the session token for the clock.
+func EncodeSessionToken(clock VectorClock) string {
+	b, _ := json.Marshal(clock)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeSessionToken returns the clock of a session token.
+func DecodeSessionToken(token string) (VectorClock, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session token: %v", err)
+	}
+	clock := VectorClock{}
+	if err := json.Unmarshal(b, &clock); err != nil {
+		return nil, fmt.Errorf("invalid session token: %v", err)
+	}
+	return clock, nil
+}
+
+// withSession gives clients read-your-writes and monotonic reads across
+// replicas. Requests with a session token wait, up to 'timeout', for the
+// replica to have seen everything in the token, and fail with 503 Service
+// Unavailable if it doesn't, so the client can try another replica. Every
+// response carries a token with the replica's clock merged in, which the
+// client sends with its next request.
+func withSession(r *Replica, timeout time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		session := VectorClock{}
+		if token := req.Header.Get(sessionHeader); token != "" {
+			var err error
+			if session, err = DecodeSessionToken(token); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !waitForClock(r, session, timeout, req.Context().Done()) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "replica hasn't caught up with the session", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		h.ServeHTTP(&sessionWriter{ResponseWriter: w, r: r, session: session}, req)
+	})
+}
+
+// sessionWriter sets the session token when the response is written, so it
+// includes the events generated by the request.
+type sessionWriter struct {
+	http.ResponseWriter
+	r       *Replica
+	session VectorClock
+	written bool
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		w.session.Merge(w.r.Clock())
+		w.Header().Set(sessionHeader, EncodeSessionToken(w.session))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the session writer.
+func (w *sessionWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// waitForClock waits for the replica to have seen every event in 'clock',
+// returning false if it hasn't by the timeout, or 'done' is closed.
+func waitForClock(r *Replica, clock VectorClock, timeout time.Duration, done <-chan struct{}) bool {
+	// subscribe before checking, so no event is missed in between.
+	entries, cancel := r.Feed().Subscribe(r.Feed().Len())
+	defer cancel()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if r.Clock().Covers(clock) {
+			return true
+		}
+		select {
+		case _, ok := <-entries:
+			if !ok {
+				// the replica was closed.
+				return r.Clock().Covers(clock)
+			}
+		case <-timer.C:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SessionChecker is an http.RoundTripper for clients of the resource API,
+// see NewAPI, that checks the servers keep the session guarantees: a client
+// reads its own writes, and never reads an older version of a node than it
+// has already read. It keeps the client's session, sending the token of
+// every response with the next request, so the client can go to any
+// replica. Broken guarantees are collected rather than failing requests, so
+// it can be left running against real servers, e.g. in soak tests.
+// It is safe for concurrent use.
+type SessionChecker struct {
+	// Transport makes the requests, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	mu         sync.Mutex
+	session    VectorClock
+	seen       map[string]sessionObservation
+	violations []string
+}
+
+// sessionObservation is the latest version of a node the client has seen,
+// and whether it saw it by writing it.
+type sessionObservation struct {
+	version VectorClock
+	write   bool
+}
+
+// RoundTrip makes the request with the session token, and checks the
+// response against what the session has seen.
+func (c *SessionChecker) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	session := c.session.Copy()
+	c.mu.Unlock()
+
+	req = req.Clone(req.Context())
+	if len(session) > 0 {
+		req.Header.Set(sessionHeader, EncodeSessionToken(session))
+	}
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observe(req, resp, session)
+	return resp, nil
+}
+
+// Violations returns the broken guarantees found so far, oldest first.
+func (c *SessionChecker) Violations() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.violations...)
+}
+
+// observe checks a response to a request made with 'session'. c.mu must be
+// held.
+func (c *SessionChecker) observe(req *http.Request, resp *http.Response, session VectorClock) {
+	if resp.StatusCode/100 != 2 {
+		// e.g. 503 from a replica that hasn't caught up, which is how a
+		// server keeps the guarantees.
+		return
+	}
+	request := req.Method + " " + req.URL.Path
+
+	if token := resp.Header.Get(sessionHeader); token != "" {
+		clock, err := DecodeSessionToken(token)
+		if err != nil {
+			c.violate("%s: %v", request, err)
+		} else {
+			if !clock.Covers(session) {
+				c.violate("%s: session went back from %v to %v", request, session, clock)
+			}
+			if c.session == nil {
+				c.session = VectorClock{}
+			}
+			c.session.Merge(clock)
+		}
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "nodes" {
+		return
+	}
+	key := parts[1]
+	if req.Method == http.MethodDelete {
+		delete(c.seen, key)
+		return
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return
+	}
+	version, err := DecodeSessionToken(strings.Trim(etag, `"`))
+	if err != nil {
+		c.violate("%s: invalid ETag: %v", request, err)
+		return
+	}
+
+	// versions made concurrently don't order, only one that happened
+	// before what the client has seen is a step back.
+	if prev, exists := c.seen[key]; exists && prev.version.Covers(version) && !version.Covers(prev.version) {
+		guarantee := "monotonic reads"
+		if prev.write {
+			guarantee = "read your writes"
+		}
+		c.violate("%s: %s broken, served version %v after %v", request, guarantee, version, prev.version)
+		return
+	}
+	if c.seen == nil {
+		c.seen = map[string]sessionObservation{}
+	}
+	c.seen[key] = sessionObservation{version: version, write: req.Method != http.MethodGet}
+}
+
+// violate records a broken guarantee. c.mu must be held.
+func (c *SessionChecker) violate(format string, args ...any) {
+	c.violations = append(c.violations, fmt.Sprintf(format, args...))
+}
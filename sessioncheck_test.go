@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCheckerFindsReplicasBehindTheClient(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		sessions bool
+		broken   bool
+	}{
+		{name: "with sessions", sessions: true},
+		{name: "without sessions", broken: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := New(WithID(1)), New(WithID(2))
+			b.Apply(a.Insert("x", rootKey))
+
+			serve := func(r *Replica) *httptest.Server {
+				h := NewAPI(r)
+				if tc.sessions {
+					h = withSession(r, 50*time.Millisecond, h)
+				}
+				return httptest.NewServer(h)
+			}
+			sa, sb := serve(a), serve(b)
+			defer sa.Close()
+			defer sb.Close()
+
+			checker := &SessionChecker{}
+			client := &http.Client{Transport: checker}
+			do := func(method, url, body string) int {
+				req, err := http.NewRequest(method, url, strings.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			// the client writes to a, then reads from b, which hasn't got
+			// the write yet.
+			if status := do(http.MethodPut, sa.URL+"/nodes/x", "hello"); status != http.StatusOK {
+				t.Fatalf("PUT gave %d", status)
+			}
+			status := do(http.MethodGet, sb.URL+"/nodes/x", "")
+			if tc.sessions && status != http.StatusServiceUnavailable {
+				t.Errorf("GET from a replica behind the session gave %d", status)
+			}
+
+			violations := checker.Violations()
+			if broken := len(violations) > 0; broken != tc.broken {
+				t.Fatalf("violations are %q", violations)
+			}
+			if tc.broken && !strings.Contains(violations[0], "read your writes") {
+				t.Errorf("violation is %q, want read your writes", violations[0])
+			}
+
+			// once b catches up, the guarantees hold either way.
+			for _, entry := range a.Feed().Tail(a.Feed().Len()) {
+				b.Apply(entry.Event)
+			}
+			if status := do(http.MethodGet, sb.URL+"/nodes/x", ""); status != http.StatusOK {
+				t.Errorf("GET from a replica that caught up gave %d", status)
+			}
+			if got := checker.Violations(); len(got) != len(violations) {
+				t.Errorf("violations are %q after catching up", got[len(violations):])
+			}
+		})
+	}
+}
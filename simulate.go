@@ -0,0 +1,421 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// SimulationConfig configures a Simulation.
+type SimulationConfig struct {
+	// Seed seeds every random choice, so a simulation with the same config
+	// always runs the same way.
+	Seed int64
+	// Replicas is the number of replicas.
+	Replicas int
+	// Steps is the number of local operations made across all replicas.
+	Steps int
+	// Insert, Move, Delete, Set and Undo are the relative weights of each
+	// kind of local operation.
+	Insert, Move, Delete, Set, Undo int
+	// UnderRoot and UnderLast are the probabilities that an inserted item
+	// goes under the root, making the tree wide, or under the last item
+	// inserted by the same replica, making it deep. Otherwise it goes under
+	// a random item.
+	UnderRoot, UnderLast float64
+	// MaxLatency is the maximum number of steps an event takes to reach
+	// another replica, 0 delivers events before the next operation. Each
+	// delivery picks its own latency, so events are reordered.
+	MaxLatency int
+	// DropRate is the probability that a delivery is lost. Lost events are
+	// sent again, with a new latency, as a replica would after a timeout.
+	DropRate float64
+	// DuplicateRate is the probability that a delivery is repeated later.
+	DuplicateRate float64
+	// PartitionRate is the probability, at each step, that the network
+	// splits the replicas into two random sides for PartitionSteps steps.
+	// Events can't cross between sides until the partition heals.
+	PartitionRate  float64
+	PartitionSteps int
+	// CrashRate is the probability, at each step, that a random replica
+	// crashes. It is down for CrashSteps steps, missing the events sent to
+	// it, and then recovers from the events it had applied.
+	CrashRate  float64
+	CrashSteps int
+	// Reference also checks, at every convergence check, that each replica
+	// has the document a naive reference implementation makes from the
+	// events the replica applied.
+	Reference bool
+	// CheckEvery is the number of steps between convergence checks, which
+	// heal any partition, recover crashed replicas, deliver every event in
+	// flight and then compare the replicas. The replicas are always checked
+	// at the end.
+	CheckEvery int
+}
+
+// DefaultSimulationConfig returns a config for a small, busy simulation.
+func DefaultSimulationConfig() SimulationConfig {
+	return SimulationConfig{
+		Replicas:       3,
+		Steps:          1000,
+		Insert:         5,
+		Move:           2,
+		Delete:         2,
+		Set:            2,
+		Undo:           1,
+		UnderRoot:      0.5,
+		MaxLatency:     20,
+		DropRate:       0.05,
+		PartitionSteps: 50,
+		CrashSteps:     50,
+		CheckEvery:     100,
+	}
+}
+
+// SimulationResult summarises a simulation run.
+type SimulationResult struct {
+	Operations int
+	Deliveries int
+	Dropped    int
+	Duplicated int
+	Partitions int
+	Crashes    int
+	Checks     int
+}
+
+// Simulation runs virtual replicas in a single goroutine, exchanging events
+// over a simulated network.
+type Simulation struct {
+	cfg      SimulationConfig
+	rng      *rand.Rand
+	replicas []*Replica
+	// inflight are the events sent but not yet delivered.
+	inflight []simulatedMessage
+	// side is the side of the partition each replica is on, nil when the
+	// network isn't partitioned, and healAt the step it heals at.
+	side   []int
+	healAt int
+	// recoverAt is the step each crashed replica recovers at, and stored
+	// the events it had applied when it crashed.
+	recoverAt map[int]int
+	stored    map[int][]Event
+	// last is the key of the last item inserted by each replica.
+	last   map[int]string
+	step   int
+	sent   int
+	result SimulationResult
+}
+
+type simulatedMessage struct {
+	from, to  int
+	event     Event
+	deliverAt int
+	// seq orders messages due at the same step by when they were sent.
+	seq int
+}
+
+// NewSimulation returns a simulation of cfg.Replicas replicas, with client
+// ids starting at 1.
+func NewSimulation(cfg SimulationConfig) *Simulation {
+	s := &Simulation{
+		cfg:       cfg,
+		rng:       rand.New(rand.NewSource(cfg.Seed)),
+		recoverAt: map[int]int{},
+		stored:    map[int][]Event{},
+		last:      map[int]string{},
+	}
+	for i := 0; i < cfg.Replicas; i++ {
+		s.replicas = append(s.replicas, NewReplica(i+1))
+	}
+	return s
+}
+
+// Run runs the simulation, returning an error describing the first
+// convergence check that fails.
+func (s *Simulation) Run() (SimulationResult, error) {
+	for s.step = 1; s.step <= s.cfg.Steps; s.step++ {
+		s.chaos()
+		if i := s.rng.Intn(len(s.replicas)); s.replicas[i] != nil {
+			s.operate(i)
+		}
+		s.deliver(s.step)
+
+		if s.cfg.CheckEvery > 0 && s.step%s.cfg.CheckEvery == 0 {
+			if err := s.check(); err != nil {
+				return s.result, err
+			}
+		}
+	}
+	return s.result, s.check()
+}
+
+// operate makes a random local operation on replica 'i', and sends the
+// resulting event to the other replicas.
+func (s *Simulation) operate(i int) {
+	r := s.replicas[i]
+
+	var keys []string
+	lastVisible := false
+	r.View(func(crdt *CRDT) {
+		for n := range crdt.Traverse() {
+			keys = append(keys, n.key)
+			lastVisible = lastVisible || n.key == s.last[i]
+		}
+	})
+	pick := func() string {
+		if len(keys) == 0 {
+			return rootKey
+		}
+		return keys[s.rng.Intn(len(keys))]
+	}
+
+	var e Event
+	op := s.rng.Intn(s.cfg.Insert + s.cfg.Move + s.cfg.Delete + s.cfg.Set + s.cfg.Undo)
+	switch {
+	case op < s.cfg.Insert || len(keys) == 0:
+		target := rootKey
+		switch p := s.rng.Float64(); {
+		case p < s.cfg.UnderRoot:
+		case p < s.cfg.UnderRoot+s.cfg.UnderLast:
+			if lastVisible {
+				target = s.last[i]
+			}
+		default:
+			target = pick()
+		}
+		e = r.Insert(r.NewKey(), target)
+		s.last[i] = e.ItemKey
+	case op < s.cfg.Insert+s.cfg.Move:
+		item, target := pick(), rootKey
+		r.View(func(crdt *CRDT) {
+			// moving an item under itself, or one of its descendants,
+			// would detach it from the tree.
+			if candidate := pick(); !crdt.nodes[candidate].within(crdt.nodes[item]) {
+				target = candidate
+			}
+		})
+		e = r.Insert(item, target)
+	case op < s.cfg.Insert+s.cfg.Move+s.cfg.Delete:
+		e = r.Delete(pick())
+	case op < s.cfg.Insert+s.cfg.Move+s.cfg.Delete+s.cfg.Set:
+		e = r.Set(pick(), fmt.Sprint(s.rng.Intn(100)))
+	default:
+		var ok bool
+		if e, ok = r.Undo(); !ok {
+			return
+		}
+	}
+
+	s.result.Operations++
+	for to := range s.replicas {
+		if to != i {
+			s.send(i, to, e)
+		}
+	}
+}
+
+// send puts the event in flight from replica 'from' to replica 'to', with
+// a random latency.
+func (s *Simulation) send(from, to int, e Event) {
+	s.sent++
+	s.inflight = append(s.inflight, simulatedMessage{
+		from:      from,
+		to:        to,
+		event:     e,
+		deliverAt: s.step + s.rng.Intn(s.cfg.MaxLatency+1),
+		seq:       s.sent,
+	})
+}
+
+// deliver delivers the events in flight that are due by step 'until'.
+func (s *Simulation) deliver(until int) {
+	sort.Slice(s.inflight, func(i, j int) bool {
+		a, b := s.inflight[i], s.inflight[j]
+		return a.deliverAt < b.deliverAt || (a.deliverAt == b.deliverAt && a.seq < b.seq)
+	})
+
+	due := 0
+	for due < len(s.inflight) && s.inflight[due].deliverAt <= until {
+		due++
+	}
+	messages := s.inflight[:due]
+	s.inflight = append([]simulatedMessage{}, s.inflight[due:]...)
+
+	for _, m := range messages {
+		// events that can't get through are sent again, as they would be
+		// after a timeout.
+		if s.replicas[m.to] == nil || (s.side != nil && s.side[m.to] != s.side[m.from]) {
+			s.send(m.from, m.to, m.event)
+			continue
+		}
+		if s.rng.Float64() < s.cfg.DropRate {
+			s.result.Dropped++
+			s.send(m.from, m.to, m.event)
+			continue
+		}
+		if s.rng.Float64() < s.cfg.DuplicateRate {
+			s.result.Duplicated++
+			s.send(m.from, m.to, m.event)
+		}
+		s.replicas[m.to].Apply(m.event)
+		s.result.Deliveries++
+	}
+}
+
+// chaos heals partitions and recovers replicas that are due, and then
+// maybe starts a new partition or crashes a replica.
+func (s *Simulation) chaos() {
+	if s.side != nil && s.step >= s.healAt {
+		s.side = nil
+	}
+	for i, at := range s.recoverAt {
+		if s.step >= at {
+			s.recover(i)
+		}
+	}
+
+	if s.side == nil && len(s.replicas) > 1 && s.rng.Float64() < s.cfg.PartitionRate {
+		s.result.Partitions++
+		s.side = make([]int, len(s.replicas))
+		for i := range s.side {
+			s.side[i] = s.rng.Intn(2)
+		}
+		s.healAt = s.step + s.cfg.PartitionSteps
+	}
+
+	if s.rng.Float64() < s.cfg.CrashRate {
+		if i := s.rng.Intn(len(s.replicas)); s.replicas[i] != nil {
+			s.result.Crashes++
+			feed := s.replicas[i].Feed()
+			for _, entry := range feed.Tail(feed.Len()) {
+				s.stored[i] = append(s.stored[i], entry.Event)
+			}
+			s.replicas[i] = nil
+			s.recoverAt[i] = s.step + s.cfg.CrashSteps
+		}
+	}
+}
+
+// recover restarts crashed replica 'i' from the events it had applied.
+func (s *Simulation) recover(i int) {
+	s.replicas[i] = RestoreReplica(i+1, s.stored[i])
+	delete(s.stored, i)
+	delete(s.recoverAt, i)
+}
+
+// check delivers every event in flight, then compares the replicas.
+func (s *Simulation) check() error {
+	s.side = nil
+	for i := range s.recoverAt {
+		s.recover(i)
+	}
+	for len(s.inflight) > 0 {
+		s.deliver(s.inflight[len(s.inflight)-1].deliverAt)
+	}
+	s.result.Checks++
+
+	if s.cfg.Reference {
+		for _, r := range s.replicas {
+			feed := r.Feed()
+			events := []Event{}
+			for _, entry := range feed.Tail(feed.Len()) {
+				events = append(events, entry.Event)
+			}
+			var err error
+			r.View(func(crdt *CRDT) {
+				err = diffReference(crdt, events)
+			})
+			if err != nil {
+				return fmt.Errorf("step %d: replica %d: %v", s.step, r.ID(), err)
+			}
+		}
+	}
+
+	for _, r := range s.replicas[1:] {
+		var diffs []string
+		s.replicas[0].View(func(a *CRDT) {
+			r.View(func(b *CRDT) {
+				diffs = DiffStates(a, b)
+			})
+		})
+		if len(diffs) > 0 {
+			return fmt.Errorf("step %d: replicas %d (a) and %d (b) diverged:\n%s",
+				s.step, s.replicas[0].ID(), r.ID(), strings.Join(diffs, "\n"))
+		}
+	}
+	return nil
+}
+
+// runSimulate runs a simulation configured by the flags.
+func runSimulate(args []string) error {
+	cfg, err := parseSimulationFlags("simulate", args)
+	if err != nil {
+		return err
+	}
+
+	res, err := NewSimulation(cfg).Run()
+	fmt.Printf("seed %d: %d operations, %d deliveries, %d dropped, %d duplicated, %d partitions, %d crashes, %d checks\n",
+		cfg.Seed, res.Operations, res.Deliveries, res.Dropped, res.Duplicated, res.Partitions, res.Crashes, res.Checks)
+	return err
+}
+
+// parseSimulationFlags returns the config set by the flags. The -workload
+// flag picks the config the other flags change, so they are parsed twice.
+func parseSimulationFlags(name string, args []string) (SimulationConfig, error) {
+	var workload string
+	for pass := 0; ; pass++ {
+		cfg := DefaultSimulationConfig()
+		if workload != "" {
+			w, exists := workloads[workload]
+			if !exists {
+				return cfg, fmt.Errorf("unknown workload %q, one of: %s", workload, strings.Join(workloadNames(), ", "))
+			}
+			w(&cfg)
+		}
+
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.StringVar(&workload, "workload", workload, "named workload to start from: "+strings.Join(workloadNames(), ", "))
+		fs.Int64Var(&cfg.Seed, "seed", 1, "seed of the simulation")
+		fs.IntVar(&cfg.Replicas, "replicas", cfg.Replicas, "number of replicas")
+		fs.IntVar(&cfg.Steps, "steps", cfg.Steps, "number of local operations")
+		fs.IntVar(&cfg.Insert, "insert", cfg.Insert, "weight of insert operations")
+		fs.IntVar(&cfg.Move, "move", cfg.Move, "weight of move operations")
+		fs.IntVar(&cfg.Delete, "delete", cfg.Delete, "weight of delete operations")
+		fs.IntVar(&cfg.Set, "set", cfg.Set, "weight of set operations")
+		fs.IntVar(&cfg.Undo, "undo", cfg.Undo, "weight of undo operations")
+		fs.Float64Var(&cfg.UnderRoot, "under-root", cfg.UnderRoot, "probability an insert goes under the root")
+		fs.Float64Var(&cfg.UnderLast, "under-last", cfg.UnderLast, "probability an insert goes under the last inserted item")
+		fs.IntVar(&cfg.MaxLatency, "max-latency", cfg.MaxLatency, "maximum steps an event takes to be delivered")
+		fs.Float64Var(&cfg.DropRate, "drop", cfg.DropRate, "probability a delivery is lost and sent again")
+		fs.Float64Var(&cfg.DuplicateRate, "duplicate", cfg.DuplicateRate, "probability a delivery is repeated")
+		fs.Float64Var(&cfg.PartitionRate, "partition", cfg.PartitionRate, "probability per step of a network partition")
+		fs.IntVar(&cfg.PartitionSteps, "partition-steps", cfg.PartitionSteps, "steps a partition lasts")
+		fs.Float64Var(&cfg.CrashRate, "crash", cfg.CrashRate, "probability per step of a replica crashing")
+		fs.IntVar(&cfg.CrashSteps, "crash-steps", cfg.CrashSteps, "steps a crashed replica stays down")
+		fs.BoolVar(&cfg.Reference, "reference", false, "also compare every replica with a reference implementation")
+		fs.IntVar(&cfg.CheckEvery, "check-every", cfg.CheckEvery, "steps between convergence checks, 0 to only check at the end")
+		if err := fs.Parse(args); err != nil {
+			return cfg, err
+		}
+		if workload != "" && pass == 0 {
+			continue
+		}
+
+		if cfg.Replicas < 1 || cfg.Insert+cfg.Move+cfg.Delete+cfg.Set+cfg.Undo < 1 || cfg.MaxLatency < 0 {
+			return cfg, fmt.Errorf("need at least one replica, one operation weight and a latency of 0 or more")
+		}
+		return cfg, nil
+	}
+}
+
+// within checks whether 'n' is 'ancestor' or one of its descendants.
+func (n *node) within(ancestor *node) bool {
+	for ; n != nil; n = n.parent {
+		if n == ancestor {
+			return true
+		}
+	}
+	return false
+}
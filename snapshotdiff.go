@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Snapshot is the clock and document of a replica, encoded as JSON. The
+// encoding is deterministic, so snapshots of replicas that have seen the
+// same events are equal.
+type Snapshot []byte
+
+// Snapshot returns a snapshot of the replica as it is now.
+func (r *Replica) Snapshot() Snapshot {
+	return replicaSnapshot(r)
+}
+
+const (
+	snapshotDiffVersion = 1
+	// snapshotDiffBlock is the length of the blocks of the old snapshot
+	// the new one is matched against. Shorter blocks find more matches,
+	// but index more of them.
+	snapshotDiffBlock = 16
+
+	snapshotDiffCopy byte = 0
+	snapshotDiffAdd  byte = 1
+)
+
+// ErrSnapshotDiffBase is returned by ApplySnapshotDiff for a diff that
+// wasn't made from the snapshot it is applied to.
+var ErrSnapshotDiffBase = errors.New("diff is for a different snapshot")
+
+// SnapshotDiff returns a binary patch turning 'old' into 'new', for clients
+// and backups that pull snapshots periodically rather than following the
+// events. The patch copies the runs of 'new' found in 'old' and carries the
+// rest, so it is about the size of what changed.
+//
+// It is made of a version byte, the lengths and CRC-32s of both snapshots,
+// and then operations: 0 followed by an offset into 'old' and a length
+// copies that run of 'old', and 1 followed by a length and that many bytes
+// adds the bytes. Numbers are unsigned varints.
+func SnapshotDiff(old, new Snapshot) []byte {
+	diff := []byte{snapshotDiffVersion}
+	diff = binary.AppendUvarint(diff, uint64(len(old)))
+	diff = binary.AppendUvarint(diff, uint64(crc32.ChecksumIEEE(old)))
+	diff = binary.AppendUvarint(diff, uint64(len(new)))
+	diff = binary.AppendUvarint(diff, uint64(crc32.ChecksumIEEE(new)))
+
+	// blocks maps the blocks of 'old' to where they first are.
+	blocks := map[string]int{}
+	for i := 0; i+snapshotDiffBlock <= len(old); i += snapshotDiffBlock {
+		if _, exists := blocks[string(old[i:i+snapshotDiffBlock])]; !exists {
+			blocks[string(old[i:i+snapshotDiffBlock])] = i
+		}
+	}
+
+	// added is where the bytes of 'new' not found in 'old' yet start.
+	added := 0
+	for i := 0; i+snapshotDiffBlock <= len(new); {
+		at, found := blocks[string(new[i:i+snapshotDiffBlock])]
+		if !found {
+			i++
+			continue
+		}
+
+		// grow the match both ways, back into the bytes waiting to be
+		// added as well.
+		start, end := i, i+snapshotDiffBlock
+		for start > added && at > 0 && new[start-1] == old[at-1] {
+			start--
+			at--
+		}
+		for end < len(new) && at+end-start < len(old) && new[end] == old[at+end-start] {
+			end++
+		}
+
+		diff = appendSnapshotAdd(diff, new[added:start])
+		diff = append(diff, snapshotDiffCopy)
+		diff = binary.AppendUvarint(diff, uint64(at))
+		diff = binary.AppendUvarint(diff, uint64(end-start))
+		added, i = end, end
+	}
+	return appendSnapshotAdd(diff, new[added:])
+}
+
+func appendSnapshotAdd(diff, b []byte) []byte {
+	if len(b) == 0 {
+		return diff
+	}
+	diff = append(diff, snapshotDiffAdd)
+	diff = binary.AppendUvarint(diff, uint64(len(b)))
+	return append(diff, b...)
+}
+
+// ApplySnapshotDiff applies a patch made by SnapshotDiff to the snapshot it
+// was made from, returning the new snapshot. It returns ErrSnapshotDiffBase
+// if 'old' isn't that snapshot.
+func ApplySnapshotDiff(old Snapshot, diff []byte) (Snapshot, error) {
+	d := bytes.NewReader(diff)
+	version, err := d.ReadByte()
+	if err != nil {
+		return nil, errors.New("invalid snapshot diff: empty")
+	}
+	if version != snapshotDiffVersion {
+		return nil, fmt.Errorf("invalid snapshot diff: unknown version %d", version)
+	}
+
+	var header [4]uint64
+	for i := range header {
+		if header[i], err = binary.ReadUvarint(d); err != nil {
+			return nil, fmt.Errorf("invalid snapshot diff: %v", err)
+		}
+	}
+	oldLen, oldSum, newLen, newSum := header[0], header[1], header[2], header[3]
+	if uint64(len(old)) != oldLen || uint64(crc32.ChecksumIEEE(old)) != oldSum {
+		return nil, ErrSnapshotDiffBase
+	}
+
+	// the lengths in the diff are only trusted up to the length it says
+	// the new snapshot has.
+	res := make(Snapshot, 0, min(newLen, uint64(len(old)+len(diff))))
+	for d.Len() > 0 {
+		op, _ := d.ReadByte()
+		switch op {
+		case snapshotDiffCopy:
+			at, err := binary.ReadUvarint(d)
+			if err != nil {
+				return nil, fmt.Errorf("invalid snapshot diff: %v", err)
+			}
+			n, err := binary.ReadUvarint(d)
+			if err != nil {
+				return nil, fmt.Errorf("invalid snapshot diff: %v", err)
+			}
+			if at > uint64(len(old)) || n > uint64(len(old))-at {
+				return nil, errors.New("invalid snapshot diff: copy out of range")
+			}
+			res = append(res, old[at:at+n]...)
+		case snapshotDiffAdd:
+			n, err := binary.ReadUvarint(d)
+			if err != nil {
+				return nil, fmt.Errorf("invalid snapshot diff: %v", err)
+			}
+			if n > uint64(d.Len()) {
+				return nil, errors.New("invalid snapshot diff: add out of range")
+			}
+			b := make([]byte, n)
+			d.Read(b)
+			res = append(res, b...)
+		default:
+			return nil, fmt.Errorf("invalid snapshot diff: unknown operation %d", op)
+		}
+		if uint64(len(res)) > newLen {
+			return nil, errors.New("invalid snapshot diff: longer than stated")
+		}
+	}
+
+	if uint64(len(res)) != newLen || uint64(crc32.ChecksumIEEE(res)) != newSum {
+		return nil, errors.New("invalid snapshot diff: result doesn't match its checksum")
+	}
+	return res, nil
+}
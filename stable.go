@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Lag is how far a peer is behind a replica.
+type Lag struct {
+	Peer int `json:"peer"`
+	// Acknowledged is the clock the peer has acknowledged.
+	Acknowledged VectorClock `json:"acknowledged"`
+	// Events is the number of events the replica has that the peer hasn't
+	// acknowledged.
+	Events int `json:"events"`
+	// Since is how long ago the peer last acknowledged, zero if it never
+	// has.
+	Since time.Duration `json:"since"`
+}
+
+// AddPeer adds a replica that must acknowledge events before they become
+// stable. Peers are also added by their first acknowledgement, but until
+// then the replica doesn't know to wait for them.
+func (r *Replica) AddPeer(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.acks[id]; !exists {
+		if r.acks == nil {
+			r.acks = map[int]VectorClock{}
+		}
+		r.acks[id] = VectorClock{}
+	}
+	r.updateStable()
+}
+
+// Acknowledge records that the peer has applied every event up to 'clock'.
+// Acknowledgements can arrive out of order, the peer's clock only grows.
+func (r *Replica) Acknowledge(peer int, clock VectorClock) {
+	r.mu.Lock()
+	if r.acks == nil {
+		r.acks = map[int]VectorClock{}
+	}
+	if _, exists := r.acks[peer]; !exists {
+		r.acks[peer] = VectorClock{}
+	}
+	r.acks[peer].Merge(clock)
+	if r.ackedAt == nil {
+		r.ackedAt = map[int]time.Time{}
+	}
+	r.ackedAt[peer] = time.Now()
+	stable, advanced := r.updateStable()
+	fn := r.onStable
+	r.mu.Unlock()
+
+	if advanced && fn != nil {
+		fn(stable)
+	}
+}
+
+// StableClock returns the clock of the events every peer has acknowledged,
+// i.e. the events that are causally stable: no event concurrent with them
+// can still arrive. Their tombstones can be collected and their log entries
+// compacted.
+func (r *Replica) StableClock() VectorClock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stable.Copy()
+}
+
+// ReplicationLag returns how far behind the replica each peer is, ordered
+// by peer.
+func (r *Replica) ReplicationLag() []Lag {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lags := make([]Lag, 0, len(r.acks))
+	for peer, ack := range r.acks {
+		lag := Lag{Peer: peer, Acknowledged: ack.Copy()}
+		for id, t := range r.clock {
+			if t > ack[id] {
+				lag.Events += t - ack[id]
+			}
+		}
+		if at, exists := r.ackedAt[peer]; exists {
+			lag.Since = time.Since(at)
+		}
+		lags = append(lags, lag)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Peer < lags[j].Peer })
+	return lags
+}
+
+// SetStableHandler sets a function called with the new StableClock every
+// time it advances. It is called without the replica locked.
+func (r *Replica) SetStableHandler(fn func(stable VectorClock)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onStable = fn
+}
+
+// updateStable recomputes the stable clock from the replica's clock and the
+// peers' acknowledgements, returning it and whether it advanced. r.mu must
+// be held.
+func (r *Replica) updateStable() (VectorClock, bool) {
+	stable := VectorClock{}
+	for id, t := range r.clock {
+		for _, ack := range r.acks {
+			if ack[id] < t {
+				t = ack[id]
+			}
+		}
+		if t > 0 {
+			stable[id] = t
+		}
+	}
+
+	advanced := false
+	for id, t := range stable {
+		if t > r.stable[id] {
+			advanced = true
+		}
+	}
+	// a new peer can move the stable clock back, events it hasn't
+	// acknowledged aren't stable any more.
+	r.stable = stable
+	if advanced {
+		r.crdt.compactMoves(stable)
+	}
+	return stable.Copy(), advanced
+}
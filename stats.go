@@ -0,0 +1,73 @@
+package main
+
+// Stats describes the size and shape of a CRDT.
+type Stats struct {
+	// Nodes is the number of nodes in the document, i.e. returned by Traverse.
+	Nodes int
+	// Tombstones is the number of deleted nodes kept under the ghost node.
+	Tombstones int
+	// Purged is the number of tombstones removed by PurgeTombstones so far.
+	Purged int
+	// Ghosts is the number of nodes under the ghost node that were created
+	// for unknown targets and are waiting for their update event.
+	Ghosts int
+	// MaxDepth is the depth of the deepest node, the children of the root
+	// are at depth 1.
+	MaxDepth int
+	// AverageChildren is the average number of children of the nodes in
+	// the document.
+	AverageChildren float64
+	// Actors is the number of distinct client ids in all the vector clocks.
+	Actors int
+	// ClockEntries is the total number of entries in all the vector clocks.
+	ClockEntries int
+}
+
+// Stats returns statistics about the CRDT, which can be used to decide when
+// it has grown enough to need compacting.
+func (crdt *CRDT) Stats() Stats {
+	stats := Stats{Purged: crdt.purged}
+
+	for _, n := range crdt.nodes[ghostKey].children.slice() {
+		// unknown targets are created without a vector clock.
+		if len(n.latestVectorClock) == 0 {
+			stats.Ghosts++
+		} else {
+			stats.Tombstones++
+		}
+	}
+
+	children := 0
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		// skip the same nodes as Traverse does.
+		if !(n.key == rootKey || n.key == ghostKey || n.parent.key == ghostKey) {
+			stats.Nodes++
+			children += n.children.len()
+			if depth > stats.MaxDepth {
+				stats.MaxDepth = depth
+			}
+		}
+		for _, c := range n.children.slice() {
+			walk(c, depth+1)
+		}
+	}
+	walk(crdt.nodes[rootKey], 0)
+
+	if stats.Nodes > 0 {
+		stats.AverageChildren = float64(children) / float64(stats.Nodes)
+	}
+
+	actors := map[int]bool{}
+	for _, n := range crdt.nodes {
+		for _, clock := range []VectorClock{n.latestVectorClock, n.valueVectorClock, n.rankVectorClock} {
+			for id := range clock {
+				actors[id] = true
+			}
+			stats.ClockEntries += len(clock)
+		}
+	}
+	stats.Actors = len(actors)
+
+	return stats
+}
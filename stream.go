@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// streamBatchSize is the number of nodes read from the replica at a time
+// by the traversal stream, the replica isn't blocked while they are written.
+const streamBatchSize = 1000
+
+// NewTraversalStream returns an http.Handler that streams the nodes of the
+// replica's document in order as newline delimited JSON.
+//
+// The 'cursor' query parameter resumes the traversal after the node with
+// that key, which is the last key the client received, and 'limit' caps the
+// number of nodes returned. If the cursor node has been deleted since, the
+// traversal cannot be resumed and 410 Gone is returned.
+func NewTraversalStream(r *Replica) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		cursor := req.URL.Query().Get("cursor")
+		if cursor == "" {
+			cursor = rootKey
+		}
+
+		limit := -1
+		if l := req.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !apiExists(r, cursor) {
+			http.Error(w, "cursor no longer exists", http.StatusGone)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+
+		for limit != 0 && req.Context().Err() == nil {
+			batch := streamBatchSize
+			if limit > 0 && limit < batch {
+				batch = limit
+			}
+
+			nodes := make([]apiNode, 0, batch)
+			r.View(func(crdt *CRDT) {
+				n, ok := apiLookup(crdt, cursor)
+				if !ok {
+					// the cursor was deleted mid stream, the client can
+					// tell from the last key it received.
+					return
+				}
+				for n = crdt.After(n); n != nil && len(nodes) < batch; n = crdt.After(n) {
+					nodes = append(nodes, newAPINode(n))
+				}
+			})
+
+			for _, n := range nodes {
+				if err := enc.Encode(n); err != nil {
+					return
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			if len(nodes) < batch {
+				return
+			}
+			cursor = nodes[len(nodes)-1].Key
+			if limit > 0 {
+				limit -= len(nodes)
+			}
+		}
+	})
+}
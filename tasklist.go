@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// taskAttributesKey is the key of the node holding the attributes of
+	// the tasks in a TaskList, which isn't itself a task.
+	taskAttributesKey = "tasklist.attributes"
+	// taskDonePrefix, taskDuePrefix and taskAssigneesPrefix prefix the keys
+	// of the nodes holding each task's attributes.
+	taskDonePrefix      = "tasklist.done."
+	taskDuePrefix       = "tasklist.due."
+	taskAssigneesPrefix = "tasklist.assignees."
+)
+
+// Task is a task of a TaskList.
+type Task struct {
+	Key   string
+	Title string
+	// Parent is the key of the task this is a subtask of, or "" for a top
+	// level task.
+	Parent    string
+	Done      bool
+	Due       time.Time
+	Assignees []string
+}
+
+// TaskList is a checklist of tasks, which can have subtasks, on top of a
+// replica of its own. The tasks are nodes with their title as the value,
+// and their attributes are kept under a separate node of the replica, each
+// with the semantics that suit it:
+//
+//   - done is a flag where marking a task done wins over concurrently
+//     marking it not done. Marking it done adds a node under the task's
+//     done node, and marking it not done deletes the ones seen so far.
+//   - due is a last writer wins register, the value of the task's due node.
+//   - assignees are a set where adding an assignee wins over concurrently
+//     removing them. Each assignment is a node under the task's assignees
+//     node, and removing an assignee deletes the ones seen so far.
+//
+// It is safe for concurrent use.
+type TaskList struct {
+	mu sync.Mutex
+	r  *Replica
+}
+
+// NewTaskList returns a task list stored in 'r', which should be a replica
+// used only by the task list.
+func NewTaskList(r *Replica) *TaskList {
+	return &TaskList{r: r}
+}
+
+// Add adds a task with the title, as a subtask of 'parent' or as a top
+// level task if 'parent' is "". It returns the new task's key and the
+// events to send to the other replicas of the task list.
+func (l *TaskList) Add(title, parent string) (string, []Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if parent == "" {
+		parent = rootKey
+	}
+	key := l.r.NewKey()
+	return key, []Event{l.r.Insert(key, parent), l.r.Set(key, title)}
+}
+
+// Rename sets the title of the task.
+func (l *TaskList) Rename(key, title string) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return []Event{l.r.Set(key, title)}
+}
+
+// Remove deletes the task. Its subtasks move up to its parent.
+func (l *TaskList) Remove(key string) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return []Event{l.r.Delete(key)}
+}
+
+// SetDone marks the task done, or not done.
+func (l *TaskList) SetDone(key string, done bool) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// marking it done always adds a new mark, even if it's done already, so
+	// that it wins over marking it not done concurrently, which only
+	// deletes the marks seen so far.
+	marks := l.children(taskDonePrefix + key)
+	var events []Event
+	if done {
+		events = append(l.ensure(taskDonePrefix+key), l.r.Insert(l.r.NewKey(), taskDonePrefix+key))
+	}
+	for _, mark := range marks {
+		events = append(events, l.r.Delete(mark.key))
+	}
+	return events
+}
+
+// SetDue sets when the task is due, the zero time clearing it.
+func (l *TaskList) SetDue(key string, due time.Time) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	value := ""
+	if !due.IsZero() {
+		value = due.UTC().Format(time.RFC3339)
+	}
+	events := l.ensure(taskDuePrefix + key)
+	return append(events, l.r.Set(taskDuePrefix+key, value))
+}
+
+// Assign adds the assignee to the task.
+func (l *TaskList) Assign(key, assignee string) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// as with SetDone, a new assignment replaces the ones seen so far.
+	var seen []*node
+	for _, n := range l.children(taskAssigneesPrefix + key) {
+		if n.value == assignee {
+			seen = append(seen, n)
+		}
+	}
+	events := l.ensure(taskAssigneesPrefix + key)
+	assignment := l.r.NewKey()
+	events = append(events, l.r.Insert(assignment, taskAssigneesPrefix+key), l.r.Set(assignment, assignee))
+	for _, n := range seen {
+		events = append(events, l.r.Delete(n.key))
+	}
+	return events
+}
+
+// Unassign removes the assignee from the task.
+func (l *TaskList) Unassign(key, assignee string) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []Event
+	for _, n := range l.children(taskAssigneesPrefix + key) {
+		if n.value == assignee {
+			events = append(events, l.r.Delete(n.key))
+		}
+	}
+	return events
+}
+
+// Apply applies an event from another replica of the task list.
+func (l *TaskList) Apply(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.r.Apply(e)
+}
+
+// Tasks returns every task, in the order of the tree, subtasks after their
+// parent.
+func (l *TaskList) Tasks() []Task {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var tasks []Task
+	l.r.View(func(crdt *CRDT) {
+		attributes := crdt.nodes[taskAttributesKey]
+		for n := range crdt.Traverse() {
+			if attributes != nil && n.within(attributes) {
+				continue
+			}
+			tasks = append(tasks, crdt.task(n))
+		}
+	})
+	return tasks
+}
+
+// Task returns the task with the key, and false if there is no such task.
+func (l *TaskList) Task(key string) (Task, bool) {
+	for _, task := range l.Tasks() {
+		if task.Key == key {
+			return task, true
+		}
+	}
+	return Task{}, false
+}
+
+// task returns the task of the node 'n'.
+func (crdt *CRDT) task(n *node) Task {
+	task := Task{Key: n.key, Title: n.value}
+	if n.parent.key != rootKey {
+		task.Parent = n.parent.key
+	}
+	if done, exists := crdt.nodes[taskDonePrefix+n.key]; exists {
+		task.Done = done.children.len() > 0
+	}
+	if due, exists := crdt.nodes[taskDuePrefix+n.key]; exists {
+		task.Due, _ = time.Parse(time.RFC3339, due.value)
+	}
+	if assignees, exists := crdt.nodes[taskAssigneesPrefix+n.key]; exists {
+		seen := map[string]bool{}
+		for _, c := range assignees.children.slice() {
+			if !seen[c.value] {
+				seen[c.value] = true
+				task.Assignees = append(task.Assignees, c.value)
+			}
+		}
+		sort.Strings(task.Assignees)
+	}
+	return task
+}
+
+// children returns the children of the node with the key. l.mu must be
+// held.
+func (l *TaskList) children(key string) []*node {
+	var children []*node
+	l.r.View(func(crdt *CRDT) {
+		if n, exists := crdt.nodes[key]; exists {
+			children = n.children.slice()
+		}
+	})
+	return children
+}
+
+// ensure inserts the attribute node with the key, and the node holding the
+// attributes, unless they are already in place, returning the events.
+// Replicas inserting the same node concurrently put it in the same place.
+// l.mu must be held.
+func (l *TaskList) ensure(key string) []Event {
+	var events []Event
+	for _, n := range []struct{ key, parent string }{{taskAttributesKey, rootKey}, {key, taskAttributesKey}} {
+		var placed bool
+		l.r.View(func(crdt *CRDT) {
+			node, exists := crdt.nodes[n.key]
+			placed = exists && node.parent != nil && node.parent.key == n.parent
+		})
+		if placed {
+			continue
+		}
+		// a replica can have moved the parent under the node, it is then
+		// left where it is.
+		if e, err := l.r.TryInsert(n.key, n.parent); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
@@ -0,0 +1,40 @@
+package main
+
+// NewFromTemplate returns a new replica, generating events as client 'id'
+// and configured by the options, holding a copy of the bundle's document.
+// Every node of the copy gets a new key from NewKey and is inserted by the
+// new replica, so the copy shares neither keys nor history with the
+// template or other copies of it, e.g. for starter documents. Deleted
+// nodes aren't copied.
+func NewFromTemplate(b *Bundle, id int, opts ...Option) *Replica {
+	template := NewCRDT()
+	for _, e := range b.Log {
+		template.Apply(e)
+	}
+
+	// children holds the keys of the children of each node, in order, and
+	// values the value of each node.
+	children := map[string][]string{}
+	values := map[string]string{}
+	for n := range template.Traverse() {
+		children[n.parent.key] = append(children[n.parent.key], n.key)
+		values[n.key] = n.value
+	}
+
+	r := New(append([]Option{WithID(id)}, opts...)...)
+	var copyChildren func(from, to string)
+	copyChildren = func(from, to string) {
+		// the newest child comes first, so they are inserted last first.
+		kids := children[from]
+		for i := len(kids) - 1; i >= 0; i-- {
+			key := r.NewKey()
+			r.Insert(key, to)
+			if values[kids[i]] != "" {
+				r.Set(key, values[kids[i]])
+			}
+			copyChildren(kids[i], key)
+		}
+	}
+	copyChildren(rootKey, rootKey)
+	return r
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks the structural invariants of the CRDT: every node is
+// reachable from the root, parent and child links agree, there are no
+// cycles, the ghost node is the first child of the root, the children of
+// every node are in the order of the CRDT's Ordering, and the treaps
+// holding them are consistent. It returns an error listing every
+// violation, or nil.
+//
+// The order of children is checked between neighbours with sortsBefore,
+// the rule children are inserted by. It is a total order for either
+// Ordering, so this is enough to catch a child inserted in the wrong place
+// without making Validate quadratic in the number of children.
+func (crdt *CRDT) Validate() error {
+	var violations []error
+	fail := func(format string, args ...any) {
+		violations = append(violations, fmt.Errorf(format, args...))
+	}
+
+	root, ghost := crdt.nodes[rootKey], crdt.nodes[ghostKey]
+	if root == nil || ghost == nil {
+		return errors.New("root or ghost node missing")
+	}
+	if root.parent != nil {
+		fail("root has parent %s", root.parent.key)
+	}
+	if ghost.parent != root || root.children.first() != ghost {
+		fail("ghost is not the first child of root")
+	}
+
+	seen := map[*node]bool{root: true}
+	var walk func(n *node)
+	walk = func(n *node) {
+		if err := treapValid(n.children.root, nil); err != nil {
+			fail("children of %s: %v", n.key, err)
+			return
+		}
+
+		var prev *node
+		for _, c := range n.children.slice() {
+			if c.parent != n {
+				fail("%s is a child of %s but has parent %v", c.key, n.key, c.parent)
+			}
+			if seen[c] {
+				fail("%s is reachable more than once", c.key)
+				continue
+			}
+			seen[c] = true
+
+			// the ghost node stays first whatever its clock.
+			if prev != nil && prev.key != ghostKey && crdt.sortsBefore(c, prev) {
+				fail("children of %s out of order: %s (%s) before %s (%s)",
+					n.key, prev.key, canonicalClock(prev.latestVectorClock), c.key, canonicalClock(c.latestVectorClock))
+			}
+			prev = c
+
+			walk(c)
+		}
+	}
+	walk(root)
+
+	for key, n := range crdt.nodes {
+		if n.key != key {
+			fail("node %s is indexed as %s", n.key, key)
+		}
+		if !seen[n] {
+			fail("%s is not reachable from root, it is detached or in a cycle", key)
+		}
+	}
+
+	return errors.Join(violations...)
+}
+
+// treapValid checks that the treap rooted at 't' has correct sizes, parent
+// links and priorities, 'up' being the node it should hang from.
+func treapValid(t, up *node) error {
+	if t == nil {
+		return nil
+	}
+	if t.up != up {
+		return fmt.Errorf("treap link of %s points to the wrong node", t.key)
+	}
+	if t.size != 1+treapSize(t.left)+treapSize(t.right) {
+		return fmt.Errorf("treap size of %s is %d", t.key, t.size)
+	}
+	for _, c := range []*node{t.left, t.right} {
+		if c != nil && c.priority > t.priority {
+			return fmt.Errorf("treap priority of %s is above its parent %s", c.key, t.key)
+		}
+	}
+	if err := treapValid(t.left, t); err != nil {
+		return err
+	}
+	return treapValid(t.right, t)
+}
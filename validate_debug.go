@@ -0,0 +1,17 @@
+//go:build crdtdebug
+
+package main
+
+import "fmt"
+
+// validateApply is on in builds with the crdtdebug tag, making every apply
+// check the invariants of the CRDT and panic if they are broken. It is far
+// too slow for production, but catches a broken tree at the event that
+// broke it.
+const validateApply = true
+
+func (crdt *CRDT) mustValidate(e Event) {
+	if err := crdt.Validate(); err != nil {
+		panic(fmt.Sprintf("invalid CRDT after %s %s -> %s %s:\n%v", e.Type, e.ItemKey, e.TargetItemKey, canonicalClock(e.VectorClock), err))
+	}
+}
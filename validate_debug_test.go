@@ -0,0 +1,26 @@
+//go:build crdtdebug
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestValidateAcceptsConcurrentEvents applies events made concurrently by
+// three actors, in several orders, checking every apply with Validate,
+// which panics on any state it finds invalid.
+func TestValidateAcceptsConcurrentEvents(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		data := make([]byte, 48)
+		rng.Read(data)
+		events := fuzzEvents(data)
+		for i := 0; i < 4; i++ {
+			crdt := NewCRDT()
+			for _, j := range rng.Perm(len(events)) {
+				crdt.Apply(events[j])
+			}
+		}
+	}
+}
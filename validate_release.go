@@ -0,0 +1,8 @@
+//go:build !crdtdebug
+
+package main
+
+// validateApply is off unless built with the crdtdebug tag.
+const validateApply = false
+
+func (crdt *CRDT) mustValidate(e Event) {}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidValue is wrapped by the errors of set events whose value the
+// replica's value validator rejects, and of priority events whose value
+// isn't a number.
+var ErrInvalidValue = errors.New("invalid value")
+
+// SetValueValidator sets the function checking the value of every set event
+// the replica applies, e.g. against a schema chosen by the item's key. Events
+// it returns an error for are rejected by TryApply and Check. It must only
+// depend on its arguments, so that every replica rejects the same events.
+func (r *Replica) SetValueValidator(validator func(itemKey, value string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.validator = validator
+}
+
+// Check returns why the replica would reject the event as a local
+// operation, because of its type, a cycle, its Quota or value validator, or
+// nil if it wouldn't. It is used to reject local operations before they
+// become events.
+func (r *Replica) Check(e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.check(e); err != nil {
+		return err
+	}
+	if err := r.quota.checkDocument(r.crdt, e); err != nil {
+		return err
+	}
+	return r.checkCycle(e)
+}
+
+// check returns why the replica rejects the event, local or received. r.mu
+// must be held.
+func (r *Replica) check(e Event) error {
+	if err := r.checkStructure(e); err != nil {
+		return err
+	}
+	if err := r.quota.check(e); err != nil {
+		return err
+	}
+	if e.Type == "set" && r.validator != nil {
+		if err := r.validator(e.ItemKey, e.Value); err != nil {
+			return fmt.Errorf("%w for %s: %v", ErrInvalidValue, e.ItemKey, err)
+		}
+	}
+	return nil
+}
+
+// JSONValues is a value validator accepting empty values, which new nodes
+// have, and JSON documents.
+func JSONValues(itemKey, value string) error {
+	if value != "" && !json.Valid([]byte(value)) {
+		return errors.New("not JSON")
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestValuesConvergeWhateverTheOrder(t *testing.T) {
+	events := []Event{
+		{Type: "update", ItemKey: "a", TargetItemKey: rootKey, VectorClock: VectorClock{1: 1}},
+		{Type: "set", ItemKey: "a", Value: "1", VectorClock: VectorClock{3: 2}},
+		{Type: "set", ItemKey: "a", Value: "0", VectorClock: VectorClock{1: 5, 3: 1}},
+		// happened after the first set, concurrently with the second.
+		{Type: "set", ItemKey: "a", Value: "0", VectorClock: VectorClock{1: 3, 3: 4}},
+	}
+
+	for _, order := range permutations([]int{0, 1, 2, 3}) {
+		crdt := NewCRDT()
+		for _, i := range order {
+			crdt.Apply(events[i])
+		}
+		if got := crdt.nodes["a"].value; got != "0" {
+			t.Errorf("order %v: value is %q, want %q", order, got, "0")
+		}
+	}
+}
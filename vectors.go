@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Vector is a conformance test vector: events, and the document any
+// implementation of these semantics must end up with after applying them
+// in the order given. Vectors are plain JSON so implementations in other
+// languages can load them.
+type Vector struct {
+	Name   string  `json:"name"`
+	Events []Event `json:"events"`
+	// Expected is the document in order.
+	Expected []VectorNode `json:"expected"`
+}
+
+// VectorNode is a node of the expected document of a Vector.
+type VectorNode struct {
+	Key string `json:"key"`
+	// Parent is the key of the node the item is placed under.
+	Parent string `json:"parent"`
+	Value  string `json:"value,omitempty"`
+}
+
+// NewVector applies the events to a new CRDT, recording the resulting
+// document as what is expected.
+func NewVector(name string, events []Event) Vector {
+	crdt := NewCRDT()
+	for _, e := range events {
+		crdt.Apply(e)
+	}
+	return Vector{Name: name, Events: events, Expected: vectorNodes(crdt)}
+}
+
+// Run applies the vector's events to a new CRDT and checks the result is
+// the expected document.
+func (v Vector) Run() error {
+	crdt := NewCRDT()
+	for _, e := range v.Events {
+		crdt.Apply(e)
+	}
+
+	got := vectorNodes(crdt)
+	for i := 0; i < len(got) || i < len(v.Expected); i++ {
+		switch {
+		case i >= len(got):
+			return fmt.Errorf("%s: position %d: missing %+v", v.Name, i, v.Expected[i])
+		case i >= len(v.Expected):
+			return fmt.Errorf("%s: position %d: unexpected %+v", v.Name, i, got[i])
+		case got[i] != v.Expected[i]:
+			return fmt.Errorf("%s: position %d: got %+v, want %+v", v.Name, i, got[i], v.Expected[i])
+		}
+	}
+	return nil
+}
+
+func vectorNodes(crdt *CRDT) []VectorNode {
+	nodes := []VectorNode{}
+	for n := range crdt.Traverse() {
+		nodes = append(nodes, VectorNode{Key: n.key, Parent: n.parent.key, Value: n.value})
+	}
+	return nodes
+}
+
+// replicaSnapshot returns the clock and document of the replica as JSON,
+// for the WebAssembly and C bindings.
+func replicaSnapshot(r *Replica) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot()
+}
+
+// snapshot is replicaSnapshot. r.mu must be held.
+func (r *Replica) snapshot() []byte {
+	b, _ := json.Marshal(struct {
+		Clock VectorClock  `json:"clock"`
+		Nodes []VectorNode `json:"nodes"`
+	}{Clock: r.clock, Nodes: vectorNodes(r.crdt)})
+	return b
+}
+
+// GenerateVectors returns 'count' vectors, each made of the events of a
+// random simulation of 'steps' operations over three replicas, as one of
+// the replicas applied them, plus a vector of the events used by the
+// experiment in main.
+func GenerateVectors(seed int64, count, steps int) []Vector {
+	experiment := experimentEvents()
+	events := make([]Event, len(experiment))
+	for i, e := range experiment {
+		events[i-1] = e
+	}
+	vectors := []Vector{NewVector("experiment", events)}
+
+	for i := 0; i < count; i++ {
+		cfg := DefaultSimulationConfig()
+		cfg.Seed = seed + int64(i)
+		cfg.Steps = steps
+		// workloads deliver every event before the next operation, so
+		// the vectors don't depend on how concurrent siblings are
+		// ordered, which isn't agreed on (see simulate).
+		events := GenerateWorkload(cfg)
+		vectors = append(vectors, NewVector(fmt.Sprintf("simulation-%d", cfg.Seed), events))
+	}
+	return vectors
+}
+
+// runVectors generates conformance vectors, or runs them against this
+// implementation.
+func runVectors(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: vectors generate|run [flags]")
+	}
+
+	fs := flag.NewFlagSet("vectors "+args[0], flag.ContinueOnError)
+	file := fs.String("f", "-", "file to read vectors from, or write them to, - for stdin/stdout")
+	seed := fs.Int64("seed", 1, "seed of the first generated vector")
+	count := fs.Int("n", 20, "number of simulated vectors to generate")
+	steps := fs.Int("steps", 30, "number of operations in each simulated vector")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "generate":
+		out := io.Writer(os.Stdout)
+		if *file != "-" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(GenerateVectors(*seed, *count, *steps))
+	case "run":
+		in := io.Reader(os.Stdin)
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		var vectors []Vector
+		if err := json.NewDecoder(in).Decode(&vectors); err != nil {
+			return err
+		}
+		var failures []error
+		for _, v := range vectors {
+			if err := v.Run(); err != nil {
+				failures = append(failures, err)
+			}
+		}
+		fmt.Printf("%d vectors, %d failed\n", len(vectors), len(failures))
+		return errors.Join(failures...)
+	default:
+		return fmt.Errorf("unknown vectors command %q", args[0])
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// wireVersion is the version of the JSON formats the server reads and
+	// writes: events, feed entries and API nodes. It must be increased
+	// whenever one of them changes in a way existing clients can't read.
+	wireVersion = 1
+	// versionHeader is sent by clients with the comma separated wire
+	// versions they understand, and by the server with the one it used.
+	versionHeader = "CRDT-Version"
+)
+
+// withVersion negotiates the wire version of each request. Clients that
+// don't send the header are assumed to understand the current version, and
+// clients that only understand versions the server doesn't are rejected
+// with 406 Not Acceptable before 'h' is called.
+func withVersion(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if accepted := req.Header.Get(versionHeader); accepted != "" && !acceptsVersion(accepted, wireVersion) {
+			http.Error(w, fmt.Sprintf("unsupported %s %q, supported: %d", versionHeader, accepted, wireVersion), http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set(versionHeader, strconv.Itoa(wireVersion))
+		h.ServeHTTP(w, req)
+	})
+}
+
+// acceptsVersion checks whether the comma separated list of versions
+// 'accepted' contains 'version'.
+func acceptsVersion(accepted string, version int) bool {
+	for _, v := range strings.Split(accepted, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n == version {
+			return true
+		}
+	}
+	return false
+}
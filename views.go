@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoView is returned for a published view that doesn't exist.
+var ErrNoView = errors.New("no such view")
+
+// PublishedView is a read-only snapshot of a document, e.g. for sharing a
+// link to it.
+type PublishedView struct {
+	// ID identifies the view. It is random, so it can't be guessed from
+	// the IDs of other views.
+	ID string `json:"id"`
+	// Clock is the version of the document the view is of.
+	Clock     VectorClock `json:"clock"`
+	Published time.Time   `json:"published"`
+	// body is the view's nodes, rendered once as they never change.
+	body []byte
+}
+
+// Publisher publishes views of a replica's document. Editing carries on as
+// normal, the views stay as they were when published.
+// It is safe for concurrent use.
+type Publisher struct {
+	r *Replica
+
+	mu    sync.Mutex
+	views map[string]*PublishedView
+}
+
+// NewPublisher returns a Publisher of the replica's document.
+func NewPublisher(r *Replica) *Publisher {
+	return &Publisher{r: r, views: map[string]*PublishedView{}}
+}
+
+// Publish snapshots the document as a new view.
+func (p *Publisher) Publish() (PublishedView, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return PublishedView{}, err
+	}
+
+	nodes := []apiNode{}
+	var clock VectorClock
+	p.r.View(func(crdt *CRDT) {
+		clock = p.r.clock.Copy()
+		for n := range crdt.Traverse() {
+			nodes = append(nodes, newAPINode(n))
+		}
+	})
+	body, err := json.Marshal(nodes)
+	if err != nil {
+		return PublishedView{}, err
+	}
+
+	v := &PublishedView{ID: hex.EncodeToString(id), Clock: clock, Published: time.Now(), body: body}
+	p.mu.Lock()
+	p.views[v.ID] = v
+	p.mu.Unlock()
+	return *v, nil
+}
+
+// Unpublish removes the view, so its link stops working.
+func (p *Publisher) Unpublish(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.views[id]; !exists {
+		return ErrNoView
+	}
+	delete(p.views, id)
+	return nil
+}
+
+// Views returns the published views.
+func (p *Publisher) Views() []PublishedView {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	views := make([]PublishedView, 0, len(p.views))
+	for _, v := range p.views {
+		views = append(views, *v)
+	}
+	return views
+}
+
+// NewViewsHandler returns an http.Handler serving the publisher's views:
+//
+//	POST   /views       publish a view of the document as it is now
+//	GET    /views/{id}  the nodes of the view, in the same form as GET /nodes
+//	DELETE /views/{id}  unpublish the view
+//
+// Views never change, so they are served from memory with an ETag of their
+// ID and may be cached for as long as clients like.
+func NewViewsHandler(p *Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(req.URL.Path, "/views"), "/")
+		switch {
+		case id == "" && req.Method == http.MethodPost:
+			v, err := p.Publish()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Location", "/views/"+v.ID)
+			apiWrite(w, http.StatusCreated, v)
+		case id != "" && req.Method == http.MethodGet:
+			p.mu.Lock()
+			v, exists := p.views[id]
+			p.mu.Unlock()
+			if !exists {
+				http.Error(w, ErrNoView.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", `"`+v.ID+`"`)
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			if req.Header.Get("If-None-Match") == `"`+v.ID+`"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(v.body)
+		case id != "" && req.Method == http.MethodDelete:
+			if err := p.Unpublish(id); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
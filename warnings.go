@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+)
+
+// Warnings are the thresholds past which a replica logs a warning, at the
+// warn level of its logger, about patterns that make a document slow to
+// work with: slow applies, nodes with many children and clocks with many
+// actors. Nothing is rejected, see Quota for that. Zero means no warning.
+type Warnings struct {
+	// SlowApply warns about every event taking longer than it to apply.
+	SlowApply time.Duration
+	// MaxChildren warns when a node goes over this many children.
+	MaxChildren int
+	// MaxActors warns when the replica's clock goes over this many actors,
+	// and again for every actor after.
+	MaxActors int
+}
+
+// SetWarnings sets the thresholds of the warnings logged when applying
+// events.
+func (r *Replica) SetWarnings(w Warnings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = w
+}
+
+// fanout returns the node the event can add children to, along with its
+// number of children before the event is applied, or nil if there is none
+// or the warning is off. r.mu must be held.
+func (r *Replica) fanout(e Event) (*node, int) {
+	if r.warnings.MaxChildren <= 0 {
+		return nil, 0
+	}
+	var n *node
+	switch e.Type {
+	case "update":
+		n = r.crdt.nodes[e.TargetItemKey]
+	case "delete":
+		// the children of a deleted node move to its parent.
+		if item, exists := r.crdt.nodes[e.ItemKey]; exists && item.parent != nil && item.parent.key != ghostKey {
+			n = item.parent
+		}
+	}
+	if n == nil || n.key == ghostKey {
+		return nil, 0
+	}
+	return n, n.children.len()
+}
+
+// warn logs the warnings about the applied event, which took 'took' and
+// may have added children to 'fanout', which had 'before' children.
+// r.mu must be held.
+func (r *Replica) warn(e Event, took time.Duration, fanout *node, before int) {
+	w := r.warnings
+	if w.SlowApply > 0 && took > w.SlowApply {
+		r.crdt.logger.Warn("slow apply", "type", e.Type, "item", e.ItemKey, "clock", e.VectorClock, "took", took)
+	}
+	if fanout != nil && before <= w.MaxChildren && fanout.children.len() > w.MaxChildren {
+		r.crdt.logger.Warn("node has too many children", "node", fanout.key, "children", fanout.children.len(), "max", w.MaxChildren)
+	}
+	if w.MaxActors > 0 && len(r.clock) > w.MaxActors && len(r.clock) > r.actorsWarned {
+		r.actorsWarned = len(r.clock)
+		r.crdt.logger.Warn("clock has too many actors", "actors", len(r.clock), "max", w.MaxActors, "clock", r.clock.Copy())
+	}
+}
@@ -0,0 +1,89 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"encoding/json"
+	"syscall/js"
+)
+
+// platformMain, in WebAssembly builds, exposes the CRDT to JavaScript as a
+// global 'crdt' object instead of running a command, and never returns so
+// the functions stay callable. Events cross the boundary as JSON strings,
+// encoded exactly as the server encodes them.
+//
+// Build it with 'GOOS=js GOARCH=wasm go build -o crdt.wasm' and load it
+// with the wasm_exec.js that comes with Go, in lib/wasm.
+//
+//	const r = crdt.newReplica(1)
+//	const unsubscribe = r.subscribe(e => send(e))
+//	const e = r.insert(r.newKey(), "_root")
+//	other.apply(e)
+//	r.snapshot() // {"clock": {...}, "nodes": [{"key": ..., "parent": ..., "value": ...}]}
+func platformMain() bool {
+	js.Global().Set("crdt", js.ValueOf(map[string]any{
+		"newReplica": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return newJSReplica(NewReplica(args[0].Int()))
+		}),
+	}))
+	select {}
+}
+
+func newJSReplica(r *Replica) js.Value {
+	event := func(e Event) any {
+		b, _ := json.Marshal(e)
+		return string(b)
+	}
+
+	return js.ValueOf(map[string]any{
+		"id": r.ID(),
+		"newKey": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return r.NewKey()
+		}),
+		"insert": js.FuncOf(func(this js.Value, args []js.Value) any {
+			e, err := r.TryInsert(args[0].String(), args[1].String())
+			if err != nil {
+				return js.Global().Get("Error").New(err.Error())
+			}
+			return event(e)
+		}),
+		"delete": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return event(r.Delete(args[0].String()))
+		}),
+		"set": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return event(r.Set(args[0].String(), args[1].String()))
+		}),
+		"undo": js.FuncOf(func(this js.Value, args []js.Value) any {
+			if e, ok := r.Undo(); ok {
+				return event(e)
+			}
+			return js.Null()
+		}),
+		"apply": js.FuncOf(func(this js.Value, args []js.Value) any {
+			var e Event
+			if err := json.Unmarshal([]byte(args[0].String()), &e); err != nil {
+				return js.Global().Get("Error").New(err.Error())
+			}
+			r.Apply(e)
+			return js.Undefined()
+		}),
+		// subscribe calls the function with every event applied from now
+		// on, local or remote, and returns a function that stops it.
+		"subscribe": js.FuncOf(func(this js.Value, args []js.Value) any {
+			fn := args[0]
+			entries, cancel := r.Feed().Subscribe(r.Feed().Len())
+			go func() {
+				for entry := range entries {
+					fn.Invoke(event(entry.Event))
+				}
+			}()
+			return js.FuncOf(func(this js.Value, args []js.Value) any {
+				cancel()
+				return js.Undefined()
+			})
+		}),
+		"snapshot": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return string(replicaSnapshot(r))
+		}),
+	})
+}
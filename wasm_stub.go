@@ -0,0 +1,9 @@
+//go:build !(js && wasm)
+
+package main
+
+// platformMain does nothing outside WebAssembly builds, leaving main to run
+// a command.
+func platformMain() bool {
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	ansiClear     = "\033[H\033[2J"
+	ansiHighlight = "\033[1;32m"
+	ansiReset     = "\033[0m"
+)
+
+// runWatch renders the tree of a CRDT in the terminal, redrawing it every
+// time an event is read from the input stream. Events are read as newline
+// delimited JSON, so any process that can write events to a pipe can be
+// watched.
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	file := fs.String("f", "-", "file to read events from, - for stdin")
+	recent := fs.Int("recent", 3, "number of most recent events whose items are highlighted")
+	interval := fs.Duration("interval", 0, "pause between events, useful when replaying a file")
+	var printer Printer
+	fs.BoolVar(&printer.ShowValues, "values", false, "show the value of each node")
+	fs.BoolVar(&printer.HideClocks, "no-clocks", false, "hide the clock of each node")
+	fs.BoolVar(&printer.HideGhost, "no-ghost", false, "hide deleted nodes and unknown targets")
+	fs.IntVar(&printer.MaxDepth, "depth", 0, "maximum depth of the tree shown, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	return watch(NewCRDT(), json.NewDecoder(in), os.Stdout, printer, *recent, *interval)
+}
+
+func watch(crdt *CRDT, dec *json.Decoder, out io.Writer, printer Printer, recent int, interval time.Duration) error {
+	// changed holds the item keys of the most recent events, oldest first.
+	changed := []string{}
+	applied := 0
+
+	fmt.Fprint(out, ansiClear, "waiting for events...\n")
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		crdt.Apply(e)
+		applied++
+
+		changed = append(changed, e.ItemKey)
+		if len(changed) > recent {
+			changed = changed[len(changed)-recent:]
+		}
+
+		printer.Highlight = changed
+		fmt.Fprint(out, ansiClear, printer.Print(crdt))
+		fmt.Fprintf(out, "\n%d events applied, last: %s %s %v\n", applied, e.Type, e.ItemKey, e.VectorClock)
+
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
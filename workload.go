@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// workloads are named changes to the default simulation config, giving
+// documents of different shapes and edit patterns.
+var workloads = map[string]func(cfg *SimulationConfig){
+	// deep builds long chains, each item under the previous one, with few
+	// deletes to break them up.
+	"deep": func(cfg *SimulationConfig) {
+		cfg.UnderRoot, cfg.UnderLast = 0, 0.95
+		cfg.Delete, cfg.Undo = 1, 0
+	},
+	// wide puts almost every item directly under the root.
+	"wide": func(cfg *SimulationConfig) {
+		cfg.UnderRoot, cfg.UnderLast = 0.95, 0
+	},
+	// moves mostly moves existing items around.
+	"moves": func(cfg *SimulationConfig) {
+		cfg.Insert, cfg.Move, cfg.Delete, cfg.Set, cfg.Undo = 3, 10, 1, 1, 1
+	},
+	// deletes deletes almost as much as it inserts, leaving many tombstones.
+	"deletes": func(cfg *SimulationConfig) {
+		cfg.Insert, cfg.Move, cfg.Delete, cfg.Set, cfg.Undo = 5, 1, 4, 1, 0
+	},
+	// actors spreads the edits over many replicas, growing the clocks.
+	"actors": func(cfg *SimulationConfig) {
+		cfg.Replicas = 50
+	},
+}
+
+func workloadNames() []string {
+	names := []string{}
+	for name := range workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenerateWorkload returns the events of a simulation of the config, with
+// every event delivered before the next operation, in the order they were
+// made. The same config always gives the same events, which makes them
+// suitable as repeatable input for benchmarks.
+func GenerateWorkload(cfg SimulationConfig) []Event {
+	cfg.MaxLatency = 0
+	cfg.DropRate, cfg.DuplicateRate, cfg.PartitionRate, cfg.CrashRate = 0, 0, 0, 0
+	cfg.CheckEvery = 0
+
+	sim := NewSimulation(cfg)
+	sim.Run()
+	feed := sim.replicas[0].Feed()
+	events := []Event{}
+	for _, entry := range feed.Tail(feed.Len()) {
+		events = append(events, entry.Event)
+	}
+	return events
+}
+
+// runWorkload writes the events of a generated workload to stdout as
+// newline delimited JSON, the format watch and check read.
+func runWorkload(args []string) error {
+	cfg, err := parseSimulationFlags("workload", args)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range GenerateWorkload(cfg) {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("writing events: %w", err)
+		}
+	}
+	return nil
+}